@@ -0,0 +1,322 @@
+package riptracer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Limits used when dereferencing pointer arguments
+const MaxArgStringLength = 64
+const MaxArgBufferLength = 16
+
+const ptrSize = int(unsafe.Sizeof(uintptr(0)))
+
+type ArgKind int
+
+const (
+	ArgInt ArgKind = iota
+	ArgUint
+	ArgHex
+	ArgChar
+	ArgPointer
+	ArgString
+	ArgBuffer
+	ArgFloat // float or double, passed in xmm registers on amd64
+)
+
+type ArgSpec struct {
+	Name string
+	Type string
+	Kind ArgKind
+	Size int
+}
+
+type FunctionPrototype struct {
+	ReturnType string
+	Name       string
+	Args       []ArgSpec
+}
+
+var prototypeRegMatch = regexp.MustCompile(`^\s*(?P<ret>.*?[\s\*])(?P<name>[A-Za-z_][A-Za-z0-9_:]*)\s*\((?P<args>.*)\)\s*;?\s*$`)
+var formatRegMatch = regexp.MustCompile(`%(?:%|[-+ #0']*(\d+|\*)?(?:\.(\d*|\*))?(hh|h|ll|l|L|z|j|t)?([diouxXcspnbfFeEgGaA]))`)
+
+// ParsePrototype parses a C style prototype such as
+// "int check(char *key, size_t len, struct cfg *c)"
+func ParsePrototype(proto string) (*FunctionPrototype, error) {
+	result := findNamedMatches(prototypeRegMatch, proto)
+	if len(result) == 0 {
+		return nil, fmt.Errorf("Unable to parse prototype: %s", proto)
+	}
+
+	fp := FunctionPrototype{ReturnType: strings.TrimSpace(result["ret"]), Name: result["name"]}
+
+	args := strings.TrimSpace(result["args"])
+	if args == "" || args == "void" {
+		return &fp, nil
+	}
+
+	for i, arg := range strings.Split(args, ",") {
+		arg = strings.TrimSpace(arg)
+		if arg == "..." {
+			break
+		}
+		typ, name := splitArgDeclaration(arg)
+		if name == "" {
+			name = fmt.Sprintf("arg%d", i+1)
+		}
+		kind, size, err := classifyCType(typ)
+		if err != nil {
+			return nil, err
+		}
+		fp.Args = append(fp.Args, ArgSpec{Name: name, Type: typ, Kind: kind, Size: size})
+	}
+	return &fp, nil
+}
+
+// ParseFormat parses a printf like format string such as "%s %zu %p"
+// %b is accepted as an extension to hexdump a buffer pointer
+func ParseFormat(format string) (*FunctionPrototype, error) {
+	fp := FunctionPrototype{}
+	for _, m := range formatRegMatch.FindAllStringSubmatch(format, -1) {
+		if m[0] == "%%" {
+			continue
+		}
+		// A * width or precision is taken from an int argument
+		for _, star := range []string{m[1], m[2]} {
+			if star == "*" {
+				fp.Args = append(fp.Args, ArgSpec{Name: fmt.Sprintf("arg%d", len(fp.Args)+1), Type: "*", Kind: ArgInt, Size: 4})
+			}
+		}
+		spec := ArgSpec{Name: fmt.Sprintf("arg%d", len(fp.Args)+1), Type: m[0]}
+
+		switch m[3] {
+		case "hh":
+			spec.Size = 1
+		case "h":
+			spec.Size = 2
+		case "ll", "j":
+			spec.Size = 8
+		case "l", "z", "t":
+			spec.Size = ptrSize
+		default:
+			spec.Size = 4
+		}
+
+		switch m[4] {
+		case "d", "i":
+			spec.Kind = ArgInt
+		case "u", "o":
+			spec.Kind = ArgUint
+		case "x", "X":
+			spec.Kind = ArgHex
+		case "c":
+			spec.Kind, spec.Size = ArgChar, 1
+		case "s":
+			spec.Kind, spec.Size = ArgString, ptrSize
+		case "p", "n":
+			spec.Kind, spec.Size = ArgPointer, ptrSize
+		case "f", "F", "e", "E", "g", "G", "a", "A":
+			if m[3] == "L" {
+				return nil, fmt.Errorf("Passing long double is not supported: %s", m[0])
+			}
+			// float is promoted to double in variadic calls
+			spec.Kind, spec.Size = ArgFloat, 8
+		case "b":
+			spec.Kind, spec.Size = ArgBuffer, ptrSize
+		}
+		fp.Args = append(fp.Args, spec)
+	}
+	if len(fp.Args) == 0 {
+		return nil, fmt.Errorf("No conversions found in format: %s", format)
+	}
+	return &fp, nil
+}
+
+func splitArgDeclaration(arg string) (string, string) {
+	// Arrays such as "char buf[]" are passed as a pointer
+	array := ""
+	if idx := strings.Index(arg, "["); idx >= 0 {
+		arg = strings.TrimSpace(arg[:idx])
+		array = " *"
+	}
+
+	idx := strings.LastIndexAny(arg, " *")
+	if idx < 0 || strings.HasSuffix(arg, "*") {
+		// Unnamed argument such as "int" or "char *"
+		return arg + array, ""
+	}
+
+	name := strings.TrimSpace(arg[idx+1:])
+	if cTypeKeywords[name] {
+		// Unnamed multi word type such as "unsigned long"
+		return arg + array, ""
+	}
+	return strings.TrimSpace(arg[:idx+1]) + array, name
+}
+
+var cTypeKeywords = map[string]bool{
+	"char": true, "short": true, "int": true, "long": true, "signed": true,
+	"unsigned": true, "const": true, "volatile": true, "float": true, "double": true,
+}
+
+func classifyCType(typ string) (ArgKind, int, error) {
+	t := strings.Join(strings.Fields(strings.ReplaceAll(typ, "*", " * ")), " ")
+	pointers := strings.Count(t, "*")
+	t = strings.TrimSpace(strings.ReplaceAll(t, "*", ""))
+
+	words := make([]string, 0)
+	for _, w := range strings.Fields(t) {
+		switch w {
+		case "const", "volatile", "restrict", "__restrict", "register":
+			continue
+		}
+		words = append(words, w)
+	}
+	base := strings.Join(words, " ")
+
+	if pointers > 0 {
+		if pointers == 1 {
+			switch base {
+			case "char", "signed char":
+				return ArgString, ptrSize, nil
+			case "unsigned char", "uint8_t", "u8", "u_char", "byte":
+				return ArgBuffer, ptrSize, nil
+			}
+		}
+		return ArgPointer, ptrSize, nil
+	}
+
+	switch base {
+	case "char", "signed char":
+		return ArgChar, 1, nil
+	case "unsigned char", "uint8_t", "u8", "bool", "_Bool":
+		return ArgUint, 1, nil
+	case "int8_t":
+		return ArgInt, 1, nil
+	case "short", "short int", "signed short", "int16_t":
+		return ArgInt, 2, nil
+	case "unsigned short", "unsigned short int", "uint16_t":
+		return ArgUint, 2, nil
+	case "int", "signed", "signed int", "int32_t", "pid_t", "enum":
+		return ArgInt, 4, nil
+	case "unsigned", "unsigned int", "uint32_t", "uid_t", "gid_t", "mode_t":
+		return ArgUint, 4, nil
+	case "long", "long int", "signed long", "ssize_t", "off_t", "intptr_t", "ptrdiff_t":
+		return ArgInt, ptrSize, nil
+	case "unsigned long", "unsigned long int", "size_t", "uintptr_t":
+		return ArgUint, ptrSize, nil
+	case "long long", "long long int", "signed long long", "int64_t", "off64_t":
+		return ArgInt, 8, nil
+	case "unsigned long long", "unsigned long long int", "uint64_t":
+		return ArgUint, 8, nil
+	case "float":
+		return ArgFloat, 4, nil
+	case "double":
+		return ArgFloat, 8, nil
+	case "long double":
+		return ArgFloat, 0, fmt.Errorf("Passing long double is not supported")
+	}
+
+	if strings.HasPrefix(base, "enum ") {
+		return ArgInt, 4, nil
+	}
+	if strings.HasPrefix(base, "struct ") || strings.HasPrefix(base, "union ") {
+		return ArgPointer, 0, fmt.Errorf("Passing %s by value is not supported", base)
+	}
+	if base == "" {
+		return ArgPointer, 0, fmt.Errorf("Missing type in argument: %s", typ)
+	}
+	// Unknown typedef, assume a register sized integer
+	return ArgHex, ptrSize, nil
+}
+
+// Format renders the raw argument values according to the prototype, reading
+// pointed to memory of the given pid where required
+func (fp *FunctionPrototype) Format(pid int, values []uint64) string {
+	parts := make([]string, 0, len(fp.Args))
+	for i, arg := range fp.Args {
+		if i >= len(values) {
+			break
+		}
+		v := formatArgValue(pid, arg, values[i])
+		if fp.Name != "" {
+			v = arg.Name + "=" + v
+		}
+		parts = append(parts, v)
+	}
+
+	if fp.Name == "" {
+		return strings.Join(parts, " ")
+	}
+	return fmt.Sprintf("%s(%s)", fp.Name, strings.Join(parts, ", "))
+}
+
+func formatArgValue(pid int, arg ArgSpec, value uint64) string {
+	if arg.Size > 0 && arg.Size < 8 {
+		value &= (1 << (8 * uint(arg.Size))) - 1
+	}
+
+	switch arg.Kind {
+	case ArgInt:
+		return strconv.FormatInt(signExtend(value, arg.Size), 10)
+	case ArgUint:
+		return strconv.FormatUint(value, 10)
+	case ArgHex:
+		return fmt.Sprintf("0x%x", value)
+	case ArgChar:
+		return strconv.QuoteRune(rune(byte(value)))
+	case ArgFloat:
+		if arg.Size == 4 {
+			return strconv.FormatFloat(float64(math.Float32frombits(uint32(value))), 'g', -1, 32)
+		}
+		return strconv.FormatFloat(math.Float64frombits(value), 'g', -1, 64)
+	case ArgString:
+		if value == 0 {
+			return "NULL"
+		}
+		mem := NewMemory(pid)
+		defer mem.Close()
+		str, truncated, err := mem.ReadCString(uintptr(value), MaxArgStringLength)
+		if err != nil {
+			return fmt.Sprintf("0x%x <unreadable>", value)
+		}
+		s := strconv.Quote(str)
+		if truncated {
+			s += "..."
+		}
+		return s
+	case ArgBuffer:
+		if value == 0 {
+			return "NULL"
+		}
+		data := make([]byte, MaxArgBufferLength)
+		mem := NewMemory(pid)
+		defer mem.Close()
+		n, _ := mem.ReadAt(data, uintptr(value))
+		if n == 0 {
+			return fmt.Sprintf("0x%x <unreadable>", value)
+		}
+		return fmt.Sprintf("0x%x [% x]", value, data[:n])
+	default:
+		if value == 0 {
+			return "NULL"
+		}
+		return fmt.Sprintf("0x%x", value)
+	}
+}
+
+func signExtend(value uint64, size int) int64 {
+	switch size {
+	case 1:
+		return int64(int8(value))
+	case 2:
+		return int64(int16(value))
+	case 4:
+		return int64(int32(value))
+	}
+	return int64(value)
+}
@@ -0,0 +1,117 @@
+package riptracer
+
+import "testing"
+
+func TestParsePrototype(t *testing.T) {
+	fp, err := ParsePrototype("int check(char *key, size_t len, struct cfg *c, unsigned char buf[], unsigned long)")
+	if err != nil {
+		t.Fatalf("ParsePrototype failed: %s", err)
+	}
+
+	if fp.Name != "check" || fp.ReturnType != "int" {
+		t.Fatalf("Unexpected name/return type: %q %q", fp.Name, fp.ReturnType)
+	}
+
+	expected := []ArgSpec{
+		{Name: "key", Type: "char *", Kind: ArgString, Size: ptrSize},
+		{Name: "len", Type: "size_t", Kind: ArgUint, Size: ptrSize},
+		{Name: "c", Type: "struct cfg *", Kind: ArgPointer, Size: ptrSize},
+		{Name: "buf", Type: "unsigned char *", Kind: ArgBuffer, Size: ptrSize},
+		{Name: "arg5", Type: "unsigned long", Kind: ArgUint, Size: ptrSize},
+	}
+	if len(fp.Args) != len(expected) {
+		t.Fatalf("Expected %d args, got %d: %+v", len(expected), len(fp.Args), fp.Args)
+	}
+	for i := range expected {
+		if fp.Args[i] != expected[i] {
+			t.Errorf("Arg %d: expected %+v got %+v", i, expected[i], fp.Args[i])
+		}
+	}
+
+	fp, err = ParsePrototype("double scale(float, double factor, int n)")
+	if err != nil {
+		t.Fatalf("ParsePrototype failed: %s", err)
+	}
+	floats := []ArgSpec{
+		{Name: "arg1", Type: "float", Kind: ArgFloat, Size: 4},
+		{Name: "factor", Type: "double", Kind: ArgFloat, Size: 8},
+		{Name: "n", Type: "int", Kind: ArgInt, Size: 4},
+	}
+	for i := range floats {
+		if i >= len(fp.Args) || fp.Args[i] != floats[i] {
+			t.Errorf("Arg %d: expected %+v got %+v", i, floats[i], fp.Args)
+		}
+	}
+	if _, err := ParsePrototype("int f(long double x)"); err == nil {
+		t.Errorf("Expected error for long double")
+	}
+
+	if _, err := ParsePrototype("int check(struct cfg c)"); err == nil {
+		t.Errorf("Expected error for struct passed by value")
+	}
+	if _, err := ParsePrototype("not a prototype"); err == nil {
+		t.Errorf("Expected error for invalid prototype")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	fp, err := ParseFormat("%s %zu %p %hhx %lld %b")
+	if err != nil {
+		t.Fatalf("ParseFormat failed: %s", err)
+	}
+
+	kinds := []ArgKind{ArgString, ArgUint, ArgPointer, ArgHex, ArgInt, ArgBuffer}
+	sizes := []int{ptrSize, ptrSize, ptrSize, 1, 8, ptrSize}
+	if len(fp.Args) != len(kinds) {
+		t.Fatalf("Expected %d args, got %d", len(kinds), len(fp.Args))
+	}
+	for i := range kinds {
+		if fp.Args[i].Kind != kinds[i] || fp.Args[i].Size != sizes[i] {
+			t.Errorf("Arg %d: expected kind %d size %d, got %+v", i, kinds[i], sizes[i], fp.Args[i])
+		}
+	}
+}
+
+func TestParseFormatFloatsAndEscapes(t *testing.T) {
+	fp, err := ParseFormat("100%% %%d %5.2f %-*d %e %s")
+	if err != nil {
+		t.Fatalf("ParseFormat failed: %s", err)
+	}
+
+	kinds := []ArgKind{ArgFloat, ArgInt, ArgInt, ArgFloat, ArgString}
+	if len(fp.Args) != len(kinds) {
+		t.Fatalf("Expected %d args, got %+v", len(kinds), fp.Args)
+	}
+	for i := range kinds {
+		if fp.Args[i].Kind != kinds[i] {
+			t.Errorf("Arg %d: expected kind %d, got %+v", i, kinds[i], fp.Args[i])
+		}
+	}
+	if _, err := ParseFormat("100%%"); err == nil {
+		t.Errorf("Expected error for a format without conversions")
+	}
+}
+
+func TestFormatArgValue(t *testing.T) {
+	tests := []struct {
+		arg      ArgSpec
+		value    uint64
+		expected string
+	}{
+		{ArgSpec{Kind: ArgInt, Size: 4}, 0xffffffff, "-1"},
+		{ArgSpec{Kind: ArgInt, Size: 4}, 0xdead00000005, "5"},
+		{ArgSpec{Kind: ArgUint, Size: 2}, 0x1ffff, "65535"},
+		{ArgSpec{Kind: ArgHex, Size: 1}, 0x1234, "0x34"},
+		{ArgSpec{Kind: ArgChar, Size: 1}, 'A', "'A'"},
+		{ArgSpec{Kind: ArgPointer, Size: 8}, 0, "NULL"},
+		{ArgSpec{Kind: ArgString, Size: 8}, 0, "NULL"},
+		{ArgSpec{Kind: ArgFloat, Size: 8}, 0x3ff8000000000000, "1.5"},
+		{ArgSpec{Kind: ArgFloat, Size: 4}, 0xc0200000, "-2.5"},
+	}
+	for _, test := range tests {
+		actual := formatArgValue(0, test.arg, test.value)
+		if actual != test.expected {
+			t.Errorf("For %+v/0x%x expected %q but got %q", test.arg, test.value, test.expected, actual)
+		}
+	}
+}
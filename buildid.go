@@ -0,0 +1,126 @@
+package riptracer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const ntGNUBuildID = 3
+
+// parseBuildID returns the hex encoded NT_GNU_BUILD_ID note of f, empty if
+// there is none
+func parseBuildID(f *elf.File) string {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			continue
+		}
+		if id := findBuildIDNote(data, f.ByteOrder, prog.Align); id != "" {
+			return id
+		}
+	}
+	// Object files and separate debug files have sections only
+	for _, sect := range f.Sections {
+		if sect.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := sect.Data()
+		if err != nil {
+			continue
+		}
+		if id := findBuildIDNote(data, f.ByteOrder, sect.Addralign); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func findBuildIDNote(data []byte, order binary.ByteOrder, align uint64) string {
+	if align != 8 {
+		align = 4
+	}
+	pad := func(n uint64) uint64 { return (n + align - 1) &^ (align - 1) }
+	for off := uint64(0); off+12 <= uint64(len(data)); {
+		namesz := uint64(order.Uint32(data[off:]))
+		descsz := uint64(order.Uint32(data[off+4:]))
+		typ := order.Uint32(data[off+8:])
+		name := off + 12
+		desc := name + pad(namesz)
+		if desc+descsz > uint64(len(data)) {
+			break
+		}
+		if typ == ntGNUBuildID && bytes.Equal(data[name:name+namesz], []byte("GNU\x00")) {
+			return hex.EncodeToString(data[desc : desc+descsz])
+		}
+		off = desc + pad(descsz)
+	}
+	return ""
+}
+
+// BuildID returns the GNU build-id of the executable of the process
+func (p *TracedProcess) BuildID() (string, error) {
+	resolver, err := p.Resolver()
+	if err != nil {
+		return "", err
+	}
+	return resolver.BuildID, nil
+}
+
+// PinBuildID pins module, the path or file name of an executable or library,
+// to a build-id, offsets only mean something for the build they were taken
+// from. An empty module pins the executable of every traced process. Loaded
+// modules are verified right away, others when an offset in them is resolved.
+// Offset based breakpoints and watchpoints are refused in another build
+// instead of patching whatever instruction happens to be at the offset.
+func (t *Tracer) PinBuildID(module string, buildID string) error {
+	t.buildIDs[module] = strings.ToLower(buildID)
+
+	for _, p := range t.session.Processes() {
+		if p.Exited {
+			continue
+		}
+		if err := t.checkBuildID(p.Exe, true); err != nil {
+			return err
+		}
+	}
+	if module == "" {
+		return nil
+	}
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return err
+	}
+	for _, m := range procMaps {
+		if m.Pathname == module || filepath.Base(m.Pathname) == module {
+			return t.checkBuildID(m.Pathname, false)
+		}
+	}
+	return nil
+}
+
+// checkBuildID verifies the build-id of the file at path against the pins
+// matching it, exe tells whether it is the executable of a process
+func (t *Tracer) checkBuildID(path string, exe bool) error {
+	for module, want := range t.buildIDs {
+		if module == "" && !exe || module != "" && module != path && module != filepath.Base(path) {
+			continue
+		}
+		resolver, err := t.resolverForPath(path)
+		if err != nil {
+			return fmt.Errorf("Unable to read the build-id of %s: %v", path, err)
+		}
+		if resolver.BuildID != want {
+			return fmt.Errorf("Build-id of %s is %q, pinned to %q", path, resolver.BuildID, want)
+		}
+	}
+	return nil
+}
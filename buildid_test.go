@@ -0,0 +1,64 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestFindBuildIDNote(t *testing.T) {
+	note := func(name string, typ uint32, desc []byte) []byte {
+		b := make([]byte, 12)
+		binary.LittleEndian.PutUint32(b, uint32(len(name)))
+		binary.LittleEndian.PutUint32(b[4:], uint32(len(desc)))
+		binary.LittleEndian.PutUint32(b[8:], typ)
+		b = append(b, name...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return append(b, desc...)
+	}
+	// An ABI tag note before the build-id
+	data := append(note("GNU\x00", 1, []byte{0, 0, 0, 0, 3, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}),
+		note("GNU\x00", ntGNUBuildID, []byte{0xde, 0xad, 0xbe, 0xef})...)
+	if id := findBuildIDNote(data, binary.LittleEndian, 4); id != "deadbeef" {
+		t.Errorf("Got build-id %q", id)
+	}
+	if id := findBuildIDNote(data[:len(data)-1], binary.LittleEndian, 4); id != "" {
+		t.Errorf("Got build-id %q from a truncated note", id)
+	}
+}
+
+func TestCheckBuildID(t *testing.T) {
+	tracer := &Tracer{
+		resolvers: map[string]*SymbolResolver{"/opt/app": {BuildID: "aa"}, "/lib/libc.so.6": {BuildID: "bb"}},
+		buildIDs:  map[string]string{"": "aa", "libc.so.6": "bb"},
+	}
+	if err := tracer.checkBuildID("/opt/app", true); err != nil {
+		t.Error(err)
+	}
+	if err := tracer.checkBuildID("/lib/libc.so.6", false); err != nil {
+		t.Error(err)
+	}
+	tracer.buildIDs["app"] = "cc"
+	if err := tracer.checkBuildID("/opt/app", true); err == nil {
+		t.Error("Mismatching build-id accepted")
+	}
+}
+
+func TestRelativeSettersCheckBuildID(t *testing.T) {
+	tracer := newTestTracer(t, os.Getpid())
+	tracer.buildIDs = map[string]string{"": "00"}
+	tracer.prototypes = make(map[uintptr]*FunctionPrototype)
+
+	// Refused with an error instead of exiting
+	if err := tracer.SetPrototypeRelative(0x10, "int f(int a)"); err == nil {
+		t.Error("Prototype set in a mismatching build")
+	}
+	if err := tracer.SetHWBreakpointRelative(0x10, CBFunctionArgs); err == nil {
+		t.Error("Hardware breakpoint set in a mismatching build")
+	}
+	if err := tracer.SetPageWatchpointRelative(0x10, 8, CBFunctionArgs); err == nil {
+		t.Error("Page watchpoint set in a mismatching build")
+	}
+}
@@ -4,6 +4,7 @@
 package riptracer
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"golang.org/x/sys/unix"
@@ -30,11 +31,51 @@ func CBPrintStack(pid int, bp BreakPoint) {
 	var regs unix.PtraceRegs
 	check(unix.PtraceGetRegs(pid, &regs))
 
+	mem := NewMemory(pid)
+	defer mem.Close()
+
 	data := make([]byte, 0x30)
-	unix.PtracePeekData(pid, uintptr(regs.Esp), data)
-	Dump(data)
+	n, _ := mem.ReadAt(data, uintptr(regs.Esp))
+	Dump(data[:n])
 }
 
 func CBFunctionArgs(pid int, bp BreakPoint) {
-	//TODO
+	var regs unix.PtraceRegs
+	check(unix.PtraceGetRegs(pid, &regs))
+	if bp.Prototype != nil {
+		fmt.Printf("%sThread: %d: %s%s\n", Green, pid, bp.Prototype.Format(pid, readFunctionArgs(pid, &regs, bp.Prototype)), Reset)
+		return
+	}
+	mem := NewMemory(pid)
+	defer mem.Close()
+	data := make([]byte, 12)
+	mem.ReadAt(data, uintptr(regs.Esp)+4)
+	fmt.Printf("%sThread: %d: arg1: 0x%08x arg2: 0x%08x arg3: 0x%08x %s\n", Green, pid,
+		binary.LittleEndian.Uint32(data[0:]), binary.LittleEndian.Uint32(data[4:]), binary.LittleEndian.Uint32(data[8:]), Reset)
+}
+
+// readFunctionArgs reads the raw argument values at function entry using the
+// cdecl calling convention, all arguments on the stack above the return
+// address with 64 bit values taking two slots
+func readFunctionArgs(pid int, regs *unix.PtraceRegs, proto *FunctionPrototype) []uint64 {
+	mem := NewMemory(pid)
+	defer mem.Close()
+
+	values := make([]uint64, 0, len(proto.Args))
+	addr := uintptr(regs.Esp) + 4
+
+	for _, arg := range proto.Args {
+		size := 4
+		if arg.Size == 8 {
+			size = 8
+		}
+		data := make([]byte, 8)
+		_, err := mem.ReadAt(data[:size], addr)
+		if err != nil {
+			break
+		}
+		values = append(values, binary.LittleEndian.Uint64(data))
+		addr += uintptr(size)
+	}
+	return values
 }
@@ -40,13 +40,64 @@ func CBPrintStack(pid int, bp BreakPoint) {
 	var regs unix.PtraceRegs
 	check(unix.PtraceGetRegs(pid, &regs))
 
+	mem := NewMemory(pid)
+	defer mem.Close()
+
 	data := make([]byte, 0x30)
-	unix.PtracePeekData(pid, uintptr(regs.Rsp), data)
-	Dump(data)
+	n, _ := mem.ReadAt(data, uintptr(regs.Rsp))
+	Dump(data[:n])
 }
 
 func CBFunctionArgs(pid int, bp BreakPoint) {
 	var regs unix.PtraceRegs
 	check(unix.PtraceGetRegs(pid, &regs))
+	if bp.Prototype != nil {
+		fmt.Printf("%sThread: %d: %s%s\n", Green, pid, bp.Prototype.Format(pid, readFunctionArgs(pid, &regs, bp.Prototype)), Reset)
+		return
+	}
 	fmt.Printf("%sThread: %d: arg1: 0x%012x arg2: 0x%012x arg3: 0x%012x %s\n", Green, pid, regs.Rdi, regs.Rsi, regs.Rdx, Reset)
 }
+
+// readFunctionArgs reads the raw argument values at function entry using the
+// System V AMD64 calling convention, integer arguments in rdi, rsi, rdx, rcx,
+// r8, r9, floating point ones in xmm0-7 and the rest on the stack above the
+// return address
+func readFunctionArgs(pid int, regs *unix.PtraceRegs, proto *FunctionPrototype) []uint64 {
+	mem := NewMemory(pid)
+	defer mem.Close()
+
+	argRegs := []uint64{regs.Rdi, regs.Rsi, regs.Rdx, regs.Rcx, regs.R8, regs.R9}
+	values := make([]uint64, 0, len(proto.Args))
+	var fpRegs *FPRegisters
+	nextInt, nextXMM, slot := 0, 0, 0
+
+	for _, arg := range proto.Args {
+		if arg.Kind == ArgFloat && nextXMM < 8 {
+			if fpRegs == nil {
+				var err error
+				if fpRegs, err = GetFPRegisters(pid); err != nil {
+					break
+				}
+			}
+			xmm, err := fpRegs.Get(fmt.Sprintf("xmm%d", nextXMM))
+			if err != nil {
+				break
+			}
+			nextXMM++
+			values = append(values, littleEndianValue(xmm[:8]))
+			continue
+		}
+		if arg.Kind != ArgFloat && nextInt < len(argRegs) {
+			values = append(values, argRegs[nextInt])
+			nextInt++
+			continue
+		}
+		slot++
+		v, err := mem.ReadUint64(uintptr(regs.Rsp) + uintptr(8*slot))
+		if err != nil {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,282 @@
+package riptracer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// Note types not defined in debug/elf
+const (
+	NT_PRSTATUS = 1
+	NT_PRPSINFO = 3
+	NT_AUXV     = 6
+	NT_FILE     = 0x46494c45
+)
+
+type coreSegment struct {
+	start uintptr
+	end   uintptr
+	flags elf.ProgFlag
+	data  []byte
+}
+
+type coreThread struct {
+	tid    int
+	regs   unix.PtraceRegs
+	signal int
+}
+
+// WriteCore writes an ELF core file of the main process that gdb can load.
+// All threads are stopped while the core is collected and resumed afterwards,
+// the process keeps running. It must run on the tracer thread, e.g. in a
+// callback, ptrace only accepts requests from the thread that attached.
+func (t *Tracer) WriteCore(path string) error {
+	return t.writeCore(path, 0)
+}
+
+// CBWriteCore returns a callback writing a core file of the process that hit
+// the breakpoint each time. A "%d" in path is replaced with the hit count.
+func (t *Tracer) CBWriteCore(path string) CallBackFunction {
+	return func(pid int, bp BreakPoint) {
+		corePath := path
+		if strings.Contains(path, "%d") {
+			corePath = fmt.Sprintf(path, bp.Hits)
+		}
+		if err := t.writeCore(corePath, pid); err != nil {
+			log.Printf("%sFailed to write core %s: %v%s", Red, corePath, err, Reset)
+			return
+		}
+		log.Printf("Wrote core file %s", corePath)
+	}
+}
+
+// writeCore collects the core of the process of current, the main process if
+// it is 0. current is a thread that is already stopped, it is reported first
+// so gdb selects it.
+func (t *Tracer) writeCore(path string, current int) error {
+	stopped := t.stopThreads(current)
+	defer t.resumeThreads(stopped)
+
+	process := t.processOf(current)
+	pid := process.Pid
+	proc, err := t.ProcFS.Proc(pid)
+	if err != nil {
+		return err
+	}
+
+	tids := make([]int, 0)
+	if current != 0 {
+		tids = append(tids, current)
+	}
+	tasks, err := t.ProcFS.AllThreads(pid)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if task.PID != current && t.threads[task.PID] != nil {
+			tids = append(tids, task.PID)
+		}
+	}
+
+	threads := make([]coreThread, 0, len(tids))
+	for _, tid := range tids {
+		ct := coreThread{tid: tid, signal: int(unix.SIGTRAP)}
+		if err := unix.PtraceGetRegs(tid, &ct.regs); err != nil {
+			// Only threads in a ptrace stop can be included
+			continue
+		}
+		threads = append(threads, ct)
+	}
+	if len(threads) == 0 {
+		return fmt.Errorf("No stopped threads to write to core")
+	}
+
+	procMaps, err := proc.ProcMaps()
+	if err != nil {
+		return err
+	}
+	segments := t.coreSegments(pid, process.space, procMaps)
+
+	notes := new(bytes.Buffer)
+	for i, ct := range threads {
+		// The first thread's status also describes the process
+		writeNote(notes, "CORE", NT_PRSTATUS, corePrStatus(proc, ct, i == 0))
+	}
+	if info, err := corePrPsInfo(proc); err == nil {
+		writeNote(notes, "CORE", NT_PRPSINFO, info)
+	}
+	if auxv, err := os.ReadFile(fmt.Sprintf("/proc/%d/auxv", pid)); err == nil {
+		writeNote(notes, "CORE", NT_AUXV, auxv)
+	}
+	writeNote(notes, "CORE", NT_FILE, coreFileNote(procMaps))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeCoreFile(f, notes.Bytes(), segments)
+}
+
+func (t *Tracer) coreSegments(pid int, space *addressSpace, procMaps []*procfs.ProcMap) []coreSegment {
+	mem := t.Memory(pid)
+	segments := make([]coreSegment, 0, len(procMaps))
+
+	for _, m := range procMaps {
+		if m.Pathname == "[vvar]" || m.Pathname == "[vsyscall]" {
+			continue
+		}
+
+		seg := coreSegment{start: m.StartAddr, end: m.EndAddr}
+		if m.Perms != nil {
+			if m.Perms.Read {
+				seg.flags |= elf.PF_R
+			}
+			if m.Perms.Write {
+				seg.flags |= elf.PF_W
+			}
+			if m.Perms.Execute {
+				seg.flags |= elf.PF_X
+			}
+		}
+
+		if seg.flags != 0 {
+			// Unreadable parts are left zeroed
+			seg.data = make([]byte, m.EndAddr-m.StartAddr)
+			mem.ReadAt(seg.data, m.StartAddr)
+
+			// Show the original code rather than our 0xCC
+			for addr, bp := range space.breakpoints {
+				if addr >= seg.start && addr < seg.end && bp.OriginalCode != nil {
+					copy(seg.data[addr-seg.start:], *bp.OriginalCode)
+				}
+			}
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+func writeNote(buf *bytes.Buffer, name string, typ uint32, desc []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(name)+1))
+	binary.Write(buf, binary.LittleEndian, uint32(len(desc)))
+	binary.Write(buf, binary.LittleEndian, typ)
+	buf.WriteString(name)
+	buf.Write(make([]byte, align4(len(name)+1)-len(name)))
+	buf.Write(desc)
+	buf.Write(make([]byte, align4(len(desc))-len(desc)))
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// coreFileNote describes the file backed mappings, used by gdb to find shared
+// libraries. Layout is count, page size, then (start, end, page offset) per
+// mapping followed by the file names.
+func coreFileNote(procMaps []*procfs.ProcMap) []byte {
+	pageSize := uint64(os.Getpagesize())
+	entries := new(bytes.Buffer)
+	names := new(bytes.Buffer)
+	count := 0
+
+	for _, m := range procMaps {
+		if !strings.HasPrefix(m.Pathname, "/") {
+			continue
+		}
+		writeWord(entries, uint64(m.StartAddr))
+		writeWord(entries, uint64(m.EndAddr))
+		writeWord(entries, uint64(m.Offset)/pageSize)
+		names.WriteString(m.Pathname)
+		names.WriteByte(0)
+		count++
+	}
+
+	buf := new(bytes.Buffer)
+	writeWord(buf, uint64(count))
+	writeWord(buf, pageSize)
+	buf.Write(entries.Bytes())
+	buf.Write(names.Bytes())
+	return buf.Bytes()
+}
+
+func writeWord(buf *bytes.Buffer, v uint64) {
+	if ptrSize == 4 {
+		binary.Write(buf, binary.LittleEndian, uint32(v))
+	} else {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+}
+
+// corePsArgs returns the command line as stored in prpsinfo
+func corePsArgs(proc procfs.Proc) string {
+	args, err := proc.CmdLine()
+	if err != nil {
+		return ""
+	}
+	return strings.Join(args, " ")
+}
+
+func coreIds(proc procfs.Proc) (uint32, uint32) {
+	status, err := proc.NewStatus()
+	if err != nil {
+		return 0, 0
+	}
+	uid, _ := strconv.ParseUint(status.UIDs[0], 10, 32)
+	gid, _ := strconv.ParseUint(status.GIDs[0], 10, 32)
+	return uint32(uid), uint32(gid)
+}
+
+// writeCoreFile lays out the core as ELF header, program headers, the notes
+// and then page aligned memory of each PT_LOAD segment
+func writeCoreFile(f *os.File, notes []byte, segments []coreSegment) error {
+	pageSize := uint64(os.Getpagesize())
+	phnum := len(segments) + 1
+
+	offset := uint64(coreEhdrSize + phnum*corePhdrSize)
+	noteOffset := offset
+	offset += uint64(len(notes))
+
+	headers := new(bytes.Buffer)
+	headers.Write(coreElfHeader(phnum))
+	headers.Write(coreProgHeader(elf.PT_NOTE, 0, noteOffset, 0, uint64(len(notes)), 0, 0))
+
+	offset = (offset + pageSize - 1) &^ (pageSize - 1)
+	for _, seg := range segments {
+		size := uint64(seg.end - seg.start)
+		fileSize := uint64(len(seg.data))
+		headers.Write(coreProgHeader(elf.PT_LOAD, seg.flags, offset, uint64(seg.start), fileSize, size, pageSize))
+		offset += fileSize
+	}
+
+	if _, err := f.Write(headers.Bytes()); err != nil {
+		return err
+	}
+	if _, err := f.Write(notes); err != nil {
+		return err
+	}
+
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	pos = int64((uint64(pos) + pageSize - 1) &^ (pageSize - 1))
+	for _, seg := range segments {
+		if _, err := f.WriteAt(seg.data, pos); err != nil {
+			return err
+		}
+		pos += int64(len(seg.data))
+	}
+	return nil
+}
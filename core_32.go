@@ -0,0 +1,103 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+
+	"github.com/prometheus/procfs"
+)
+
+const coreEhdrSize = 52
+const corePhdrSize = 32
+
+func coreElfHeader(phnum int) []byte {
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_386),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     coreEhdrSize,
+		Ehsize:    coreEhdrSize,
+		Phentsize: corePhdrSize,
+		Phnum:     uint16(phnum),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, hdr)
+	return buf.Bytes()
+}
+
+func coreProgHeader(typ elf.ProgType, flags elf.ProgFlag, off uint64, vaddr uint64, filesz uint64, memsz uint64, align uint64) []byte {
+	prog := elf.Prog32{
+		Type:   uint32(typ),
+		Flags:  uint32(flags),
+		Off:    uint32(off),
+		Vaddr:  uint32(vaddr),
+		Filesz: uint32(filesz),
+		Memsz:  uint32(memsz),
+		Align:  uint32(align),
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, prog)
+	return buf.Bytes()
+}
+
+// struct elf_prstatus for i386
+func corePrStatus(proc procfs.Proc, ct coreThread, withTimes bool) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, [3]int32{int32(ct.signal), 0, 0}) // pr_info
+	binary.Write(buf, binary.LittleEndian, int16(ct.signal))                 // pr_cursig
+	buf.Write(make([]byte, 2))
+	binary.Write(buf, binary.LittleEndian, [2]uint32{}) // pr_sigpend, pr_sighold
+
+	var ppid, pgrp, sid int32
+	var times [8]int32
+	if stat, err := proc.Stat(); err == nil {
+		ppid, pgrp, sid = int32(stat.PPID), int32(stat.PGRP), int32(stat.Session)
+		if withTimes {
+			// Clock ticks to timeval, assuming USER_HZ of 100
+			times[0], times[1] = int32(stat.UTime/100), int32(stat.UTime%100)*10000
+			times[2], times[3] = int32(stat.STime/100), int32(stat.STime%100)*10000
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, [4]int32{int32(ct.tid), ppid, pgrp, sid})
+	binary.Write(buf, binary.LittleEndian, times)
+	binary.Write(buf, binary.LittleEndian, ct.regs)  // pr_reg, same layout as user_regs_struct
+	binary.Write(buf, binary.LittleEndian, int32(0)) // pr_fpvalid
+	return buf.Bytes()
+}
+
+// struct elf_prpsinfo for i386, note uid/gid are 16 bit here
+func corePrPsInfo(proc procfs.Proc) ([]byte, error) {
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid := coreIds(proc)
+
+	state := byte('R')
+	if len(stat.State) > 0 {
+		state = stat.State[0]
+	}
+
+	var fname [16]byte
+	var psargs [80]byte
+	copy(fname[:], stat.Comm)
+	copy(psargs[:], corePsArgs(proc))
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, state, 0, byte(int8(stat.Nice))})
+	binary.Write(buf, binary.LittleEndian, uint32(stat.Flags))
+	binary.Write(buf, binary.LittleEndian, [2]uint16{uint16(uid), uint16(gid)})
+	binary.Write(buf, binary.LittleEndian, [4]int32{int32(stat.PID), int32(stat.PPID), int32(stat.PGRP), int32(stat.Session)})
+	buf.Write(fname[:])
+	buf.Write(psargs[:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+
+	"github.com/prometheus/procfs"
+)
+
+const coreEhdrSize = 64
+const corePhdrSize = 56
+
+func coreElfHeader(phnum int) []byte {
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     coreEhdrSize,
+		Ehsize:    coreEhdrSize,
+		Phentsize: corePhdrSize,
+		Phnum:     uint16(phnum),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, hdr)
+	return buf.Bytes()
+}
+
+func coreProgHeader(typ elf.ProgType, flags elf.ProgFlag, off uint64, vaddr uint64, filesz uint64, memsz uint64, align uint64) []byte {
+	prog := elf.Prog64{
+		Type:   uint32(typ),
+		Flags:  uint32(flags),
+		Off:    off,
+		Vaddr:  vaddr,
+		Filesz: filesz,
+		Memsz:  memsz,
+		Align:  align,
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, prog)
+	return buf.Bytes()
+}
+
+// struct elf_prstatus for x86_64
+func corePrStatus(proc procfs.Proc, ct coreThread, withTimes bool) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, [3]int32{int32(ct.signal), 0, 0}) // pr_info
+	binary.Write(buf, binary.LittleEndian, int16(ct.signal))                 // pr_cursig
+	buf.Write(make([]byte, 2))
+	binary.Write(buf, binary.LittleEndian, [2]uint64{}) // pr_sigpend, pr_sighold
+
+	var ppid, pgrp, sid int32
+	var times [8]int64
+	if stat, err := proc.Stat(); err == nil {
+		ppid, pgrp, sid = int32(stat.PPID), int32(stat.PGRP), int32(stat.Session)
+		if withTimes {
+			// Clock ticks to timeval, assuming USER_HZ of 100
+			times[0], times[1] = int64(stat.UTime/100), int64(stat.UTime%100)*10000
+			times[2], times[3] = int64(stat.STime/100), int64(stat.STime%100)*10000
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, [4]int32{int32(ct.tid), ppid, pgrp, sid})
+	binary.Write(buf, binary.LittleEndian, times)
+	binary.Write(buf, binary.LittleEndian, ct.regs)  // pr_reg, same layout as user_regs_struct
+	binary.Write(buf, binary.LittleEndian, int32(0)) // pr_fpvalid
+	buf.Write(make([]byte, 4))
+	return buf.Bytes()
+}
+
+// struct elf_prpsinfo for x86_64
+func corePrPsInfo(proc procfs.Proc) ([]byte, error) {
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid := coreIds(proc)
+
+	state := byte('R')
+	if len(stat.State) > 0 {
+		state = stat.State[0]
+	}
+
+	var fname [16]byte
+	var psargs [80]byte
+	copy(fname[:], stat.Comm)
+	copy(psargs[:], corePsArgs(proc))
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, state, 0, byte(int8(stat.Nice))})
+	buf.Write(make([]byte, 4))
+	binary.Write(buf, binary.LittleEndian, uint64(stat.Flags))
+	binary.Write(buf, binary.LittleEndian, [2]uint32{uid, gid})
+	binary.Write(buf, binary.LittleEndian, [4]int32{int32(stat.PID), int32(stat.PPID), int32(stat.PGRP), int32(stat.Session)})
+	buf.Write(fname[:])
+	buf.Write(psargs[:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,148 @@
+package riptracer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteNote(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeNote(buf, "CORE", NT_AUXV, []byte{1, 2, 3, 4, 5})
+
+	expected := []byte{
+		5, 0, 0, 0, // namesz including NUL
+		5, 0, 0, 0, // descsz
+		6, 0, 0, 0, // type
+		'C', 'O', 'R', 'E', 0, 0, 0, 0,
+		1, 2, 3, 4, 5, 0, 0, 0,
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("Unexpected note encoding:\n% x\n% x", buf.Bytes(), expected)
+	}
+}
+
+func TestWriteCore(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd, err := launchTraced(LaunchOptions{Args: []string{"/bin/true"}})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	tracer := newTestTracer(t, pid)
+	tracer.Process = cmd.Process
+	tracer.addThread(pid, 0)
+	pc, err := GetReg(pid, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := tracer.originalCode(pid, uintptr(pc), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The core shows the code without our breakpoint
+	if err := tracer.session.Root().setBreakpoint(uintptr(pc), func(int, BreakPoint) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "core")
+	if err := tracer.writeCore(path, pid); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		t.Fatalf("Core has type %v", f.Type)
+	}
+
+	notes, loads := 0, 0
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_NOTE:
+			notes++
+			data, err := io.ReadAll(prog.Open())
+			if err != nil || !bytes.Contains(data, []byte("CORE\x00")) {
+				t.Errorf("Unreadable note segment: %v", err)
+			}
+		case elf.PT_LOAD:
+			loads++
+			if uintptr(pc) < uintptr(prog.Vaddr) || uintptr(pc) >= uintptr(prog.Vaddr+prog.Memsz) {
+				continue
+			}
+			if prog.Flags&elf.PF_X == 0 {
+				t.Errorf("Segment of the pc is not executable: %v", prog.Flags)
+			}
+			code := make([]byte, len(original))
+			if _, err := prog.ReadAt(code, int64(uintptr(pc)-uintptr(prog.Vaddr))); err != nil || !bytes.Equal(code, original) {
+				t.Errorf("Code at the pc is % x, expected % x (%v)", code, original, err)
+			}
+		}
+	}
+	maps, err := tracer.session.Root().MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes != 1 || loads == 0 || loads > len(maps) {
+		t.Errorf("Got %d note and %d load segments for %d mappings", notes, loads, len(maps))
+	}
+}
+
+const forkCoreProgram = `
+#include <sys/wait.h>
+#include <unistd.h>
+volatile int marker = 1;
+__attribute__((noinline)) void mark(void) { __asm__ volatile("" ::: "memory"); }
+int main(void) {
+	if (fork() == 0) {
+		marker = 0x1111;
+		mark();
+		_exit(0);
+	}
+	wait(0);
+	return 0;
+}
+`
+
+func TestWriteCoreChild(t *testing.T) {
+	prog := compileTestProgram(t, forkCoreProgram, "-O0")
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, StopAt: StopAtMain})
+	defer runtime.UnlockOSThread()
+	tracer.SetFollowForks(true)
+
+	root := tracer.Session().Root()
+	marker, err := root.SymbolAddress("marker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "core")
+	if err := root.SetBreakpointSymbol("mark", tracer.CBWriteCore(path)); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+
+	// Only the child changed the marker before the breakpoint
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	value := make([]byte, 4)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && uintptr(prog.Vaddr) <= marker && marker < uintptr(prog.Vaddr+prog.Filesz) {
+			prog.ReadAt(value, int64(marker-uintptr(prog.Vaddr)))
+		}
+	}
+	if v := binary.LittleEndian.Uint32(value); v != 0x1111 {
+		t.Errorf("Marker in the core is 0x%x, expected the child's 0x1111", v)
+	}
+}
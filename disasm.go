@@ -0,0 +1,132 @@
+package riptracer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Instructions shown by the prompt when no count is given
+const defaultDisassembleCount = 8
+
+// decodeInstruction turns these into NOPs
+var endbrNames = map[string]string{
+	"\xf3\x0f\x1e\xfa": "endbr64",
+	"\xf3\x0f\x1e\xfb": "endbr32",
+}
+
+// Instruction is a decoded instruction of the traced process
+type Instruction struct {
+	Address uintptr
+	Bytes   []byte
+	Text    string  // AT&T syntax as printed by objdump
+	Target  uintptr // Destination of a direct jump or call or address of a rip-relative operand
+	Symbol  string  // Symbol of Target
+}
+
+func (i Instruction) String() string {
+	s := fmt.Sprintf("0x%012x  %-30s %s", i.Address, fmt.Sprintf("% x", i.Bytes), i.Text)
+	if i.Symbol != "" {
+		s += fmt.Sprintf(" <%s>", i.Symbol)
+	}
+	return s
+}
+
+// Disassemble decodes count instructions at addr in the memory of tid, with
+// the original code in place of our breakpoints
+func (t *Tracer) Disassemble(tid int, addr uintptr, count int) ([]Instruction, error) {
+	procMaps, err := t.processOf(tid).MemMaps()
+	if err != nil {
+		return nil, err
+	}
+	return t.disassemble(tid, addr, count, procMaps)
+}
+
+// disassemble symbolizes targets with procMaps, not at all if it's nil
+func (t *Tracer) disassemble(tid int, addr uintptr, count int, procMaps []*procfs.ProcMap) ([]Instruction, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("Invalid instruction count %d", count)
+	}
+	code, err := t.originalCode(tid, addr, count*maxInsnLength)
+	if err != nil {
+		return nil, err
+	}
+
+	insts := make([]Instruction, 0, count)
+	for pos := 0; pos < len(code) && len(insts) < count; {
+		inst := decodeAt(code[pos:], addr+uintptr(pos))
+		if inst.Target != 0 && procMaps != nil {
+			inst.Symbol = t.symbolize(inst.Target, procMaps)
+		}
+		insts = append(insts, inst)
+		pos += len(inst.Bytes)
+	}
+	return insts, nil
+}
+
+// decodeAt decodes the instruction at the start of code, located at pc.
+// Bytes that don't decode are shown as "(bad)" like objdump does.
+func decodeAt(code []byte, pc uintptr) Instruction {
+	inst, err := decodeInstruction(code, disasmMode)
+	if err != nil || inst.Len == 0 {
+		return Instruction{Address: pc, Bytes: code[:1], Text: "(bad)"}
+	}
+	i := Instruction{Address: pc, Bytes: code[:inst.Len], Text: x86asm.GNUSyntax(inst, uint64(pc), nil)}
+	if name, ok := endbrNames[string(i.Bytes)]; ok {
+		i.Text = name
+	}
+	for _, arg := range inst.Args {
+		switch arg := arg.(type) {
+		case x86asm.Rel:
+			i.Target = pc + uintptr(inst.Len) + uintptr(int64(arg))
+		case x86asm.Mem:
+			if arg.Base == x86asm.RIP {
+				i.Target = pc + uintptr(inst.Len) + uintptr(arg.Disp)
+			}
+		}
+	}
+	return i
+}
+
+// disassembleAt shows count instructions at the pc of tid
+func (t *Tracer) disassembleAt(tid int, count int) error {
+	pc, err := GetReg(tid, "pc")
+	if err != nil {
+		return err
+	}
+	insts, err := t.Disassemble(tid, uintptr(pc), count)
+	if err != nil {
+		return err
+	}
+	procMaps, _ := t.processOf(tid).MemMaps()
+	header := fmt.Sprintf("Thread %d at 0x%x", tid, pc)
+	if sym := t.symbolize(uintptr(pc), procMaps); sym != "" {
+		header += " <" + sym + ">"
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, Blue, "----------DISASSEMBLY----------", Reset)
+	fmt.Fprintln(&b, header)
+	for idx, inst := range insts {
+		if idx == 0 {
+			fmt.Fprintf(&b, "%s%s%s\n", Green, inst, Reset)
+			continue
+		}
+		fmt.Fprintln(&b, inst)
+	}
+	fmt.Print(b.String())
+	return nil
+}
+
+// CBDisassemble returns a callback showing the next count instructions at the
+// breakpoint
+func (t *Tracer) CBDisassemble(count int) CallBackFunction {
+	return func(pid int, bp BreakPoint) {
+		if err := t.disassembleAt(pid, count); err != nil {
+			log.Printf("%sUnable to disassemble at the breakpoint in %d: %v%s", Red, pid, err, Reset)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package riptracer
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd, err := launchTraced(LaunchOptions{Args: []string{"/bin/true"}})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	tracer := newTestTracer(t, cmd.Process.Pid)
+	pc, err := GetReg(cmd.Process.Pid, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A breakpoint must not show up in the disassembly
+	original, err := tracer.Disassemble(cmd.Process.Pid, uintptr(pc), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.session.Root().setBreakpoint(uintptr(pc), func(int, BreakPoint) {}); err != nil {
+		t.Fatal(err)
+	}
+	insts, err := tracer.Disassemble(cmd.Process.Pid, uintptr(pc), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tracer.Disassemble(cmd.Process.Pid, uintptr(pc), 0); err == nil {
+		t.Error("Disassembled 0 instructions")
+	}
+	if len(insts) != 5 {
+		t.Fatalf("Got %d instructions", len(insts))
+	}
+	addr := uintptr(pc)
+	for i, inst := range insts {
+		if inst.Address != addr || inst.Text != original[i].Text {
+			t.Errorf("Instruction %d: %s, expected %s", i, inst, original[i])
+		}
+		addr += uintptr(len(inst.Bytes))
+	}
+}
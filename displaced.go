@@ -0,0 +1,265 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/arch/x86/x86asm"
+	"golang.org/x/sys/unix"
+)
+
+// Longest x86 instruction
+const maxInsnLength = 15
+
+// Registers a RIP-relative operand can be rewritten to, in order of preference
+var displacedScratchRegs = []x86asm.Reg{x86asm.RSI, x86asm.RDI, x86asm.RBX, x86asm.RCX, x86asm.RDX, x86asm.RAX}
+
+type displacedInsn struct {
+	code       []byte // Instruction to run in the scratch page
+	length     int
+	absolute   bool   // The next pc doesn't depend on where the instruction is
+	call       bool   // Pushes a return address that has to be fixed
+	scratchReg string // Register standing in for RIP, empty if none
+}
+
+// SetDisplacedStepping steps over breakpoints by running the original
+// instruction in a scratch page instead of removing the breakpoint, so other
+// threads can't run past it meanwhile. Instructions that can't be displaced
+// are stepped in place. Callbacks see the 0xCC at the breakpoint address when
+// reading memory.
+func (t *Tracer) SetDisplacedStepping(enable bool) {
+	t.displacedStepping = enable
+	if t.verbose {
+		log.Printf("SetDisplacedStepping: %t", enable)
+	}
+}
+
+// scratchPage returns the page displaced instructions are copied to, mapping
+// it in the tracee on first use
+func (t *Tracer) scratchPage(tid int) (uintptr, error) {
+	proc := t.processOf(tid)
+	if proc.space.scratch != 0 {
+		return proc.space.scratch, nil
+	}
+	addr, err := t.injectSyscall(tid, sysMmap, 0, uint64(os.Getpagesize()),
+		unix.PROT_READ|unix.PROT_WRITE|unix.PROT_EXEC, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS, ^uint64(0), 0)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to map scratch page: %v", err)
+	}
+	proc.space.scratch = uintptr(addr)
+	if t.verbose {
+		log.Printf("Scratch page for displaced stepping at 0x%x in %d", proc.space.scratch, proc.Pid)
+	}
+	return proc.space.scratch, nil
+}
+
+// originalCode reads memory at addr with any of our breakpoints undone
+func (t *Tracer) originalCode(pid int, addr uintptr, length int) ([]byte, error) {
+	code := make([]byte, length)
+	n, err := t.Memory(pid).ReadAt(code, addr)
+	if n == 0 {
+		return nil, err
+	}
+	code = code[:n]
+	for bpAddr, bp := range t.processOf(pid).space.breakpoints {
+		if bpAddr >= addr && bpAddr < addr+uintptr(n) && bp.OriginalCode != nil {
+			copy(code[bpAddr-addr:], *bp.OriginalCode)
+		}
+	}
+	return code, nil
+}
+
+// prepareDisplaced decodes the instruction in code and builds the copy that
+// is executed in the scratch page
+func prepareDisplaced(code []byte, mode int) (*displacedInsn, error) {
+	inst, err := decodeInstruction(code, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &displacedInsn{code: append([]byte{}, code[:inst.Len]...), length: inst.Len}
+	switch inst.Op {
+	case x86asm.RET, x86asm.LRET, x86asm.IRET, x86asm.IRETD, x86asm.IRETQ, x86asm.LJMP, x86asm.LCALL:
+		d.absolute = true
+	case x86asm.JMP, x86asm.CALL:
+		_, rel := inst.Args[0].(x86asm.Rel)
+		d.absolute = !rel
+		d.call = inst.Op == x86asm.CALL
+	}
+
+	for _, arg := range inst.Args {
+		if mem, ok := arg.(x86asm.Mem); ok && mem.Base == x86asm.RIP {
+			if err := d.rewriteRIPRelative(inst); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return d, nil
+}
+
+// rewriteRIPRelative turns [rip+disp32] into [reg+disp32], with reg set to
+// the address following the original instruction while stepping, like gdb
+func (d *displacedInsn) rewriteRIPRelative(inst x86asm.Inst) error {
+	var reg x86asm.Reg
+	for _, candidate := range displacedScratchRegs {
+		used := false
+		for _, arg := range inst.Args {
+			if r, ok := arg.(x86asm.Reg); ok && sameRegister(r, candidate) {
+				used = true
+			}
+		}
+		if !used {
+			reg = candidate
+			break
+		}
+	}
+	if reg == 0 {
+		return fmt.Errorf("No free register to displace %v", inst)
+	}
+
+	// Clear REX.B (or the inverted VEX.B) so the ModRM base is one of the
+	// first eight registers
+	idx := 0
+	for idx < inst.PCRelOff-1 && isLegacyPrefix(d.code[idx]) {
+		idx++
+	}
+	switch {
+	case d.code[idx]&0xf0 == 0x40:
+		d.code[idx] &^= 0x01
+	case d.code[idx] == 0xc4:
+		d.code[idx+1] |= 0x20
+	case d.code[idx] == 0x62:
+		return fmt.Errorf("Can't displace EVEX encoded %v", inst)
+	}
+
+	// mod=10 (disp32 with base register), keep the reg field
+	modrm := inst.PCRelOff - 1
+	d.code[modrm] = 0x80 | (d.code[modrm] & 0x38) | byte(reg-x86asm.RAX)
+	d.scratchReg = strings.ToLower(reg.String())
+	return nil
+}
+
+// decodeInstruction is x86asm.Decode with support for the hint NOPs at 0F 1E
+// (endbr64, endbr32), which are encoded like the NOP at 0F 1F
+func decodeInstruction(code []byte, mode int) (x86asm.Inst, error) {
+	i := 0
+	for i < len(code) && (isLegacyPrefix(code[i]) || mode == 64 && code[i]&0xf0 == 0x40) {
+		i++
+	}
+	if i+2 < len(code) && code[i] == 0x0f && code[i+1] == 0x1e {
+		if code[i+2]>>6 == 3 {
+			// Register operand, x86asm doesn't decode these NOPs
+			return x86asm.Inst{Op: x86asm.NOP, Len: i + 3}, nil
+		}
+		// The prefixes don't change the length, x86asm rejects them on 0F 1F
+		nop := append([]byte{}, code[i:]...)
+		nop[1] = 0x1f
+		inst, err := x86asm.Decode(nop, mode)
+		inst.Len += i
+		return inst, err
+	}
+	inst, err := x86asm.Decode(code, mode)
+	if err == nil && inst.Op == 0 {
+		return inst, fmt.Errorf("Unknown instruction % x", code[:inst.Len])
+	}
+	return inst, err
+}
+
+func isLegacyPrefix(b byte) bool {
+	switch b {
+	case 0xf0, 0xf2, 0xf3, 0x2e, 0x36, 0x3e, 0x26, 0x64, 0x65, 0x66, 0x67:
+		return true
+	}
+	return false
+}
+
+// sameRegister reports whether r is candidate or one of its subregisters
+func sameRegister(r x86asm.Reg, candidate x86asm.Reg) bool {
+	idx := candidate - x86asm.RAX
+	for _, base := range []x86asm.Reg{x86asm.AX, x86asm.EAX, x86asm.RAX} {
+		if r == base+idx {
+			return true
+		}
+	}
+	// Byte registers are ordered al, cl, dl, bl, ah, ch, dh, bh, spl, bpl, ...
+	if idx < 4 {
+		return r == x86asm.AL+idx || r == x86asm.AH+idx
+	}
+	return r == x86asm.AL+idx+4
+}
+
+// displacedStep executes the original instruction at the breakpoint addr out
+// of line. tid must be stopped with its pc at addr, which it is again if the
+// step fails. It reports whether the instruction was stepped, the caller must
+// not step it in place then even on error.
+func (t *Tracer) displacedStep(tid int, addr uintptr) (bool, error) {
+	code, err := t.originalCode(tid, addr, maxInsnLength)
+	if err != nil {
+		return false, err
+	}
+	insn, err := prepareDisplaced(code, disasmMode)
+	if err != nil {
+		return false, err
+	}
+	scratch, err := t.scratchPage(tid)
+	if err != nil {
+		return false, err
+	}
+	if _, err := t.Memory(tid).WriteAt(insn.code, scratch); err != nil {
+		return false, err
+	}
+
+	var saved, regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &saved); err != nil {
+		return false, err
+	}
+	regs = saved
+	if insn.scratchReg != "" {
+		writeRegister(&regs, insn.scratchReg, uint64(addr)+uint64(insn.length))
+	}
+	writeRegister(&regs, "pc", uint64(scratch))
+	if err := unix.PtraceSetRegs(tid, &regs); err != nil {
+		unix.PtraceSetRegs(tid, &saved)
+		return false, err
+	}
+
+	stepErr := t.stepThread(tid)
+	if stepErr == errThreadGone || stepErr == errStepExec {
+		return true, stepErr
+	}
+	if stepErr != nil && stepErr != errStepEvent {
+		// A faulting instruction didn't complete, its signal is delivered at
+		// the breakpoint
+		unix.PtraceSetRegs(tid, &saved)
+		return true, stepErr
+	}
+
+	// A system call stopped at an event has its return address set already
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return true, err
+	}
+	if !insn.absolute {
+		pc, _ := readRegister(&regs, "pc")
+		writeRegister(&regs, "pc", pc-uint64(scratch)+uint64(addr))
+	}
+	if insn.scratchReg != "" {
+		scratchValue, _ := readRegister(&saved, insn.scratchReg)
+		writeRegister(&regs, insn.scratchReg, scratchValue)
+	}
+	if err := unix.PtraceSetRegs(tid, &regs); err != nil {
+		return true, err
+	}
+	if insn.call {
+		sp, _ := readRegister(&regs, "sp")
+		ret := make([]byte, 8)
+		binary.LittleEndian.PutUint64(ret, uint64(addr)+uint64(insn.length))
+		if _, err := t.Memory(tid).WriteAt(ret[:ptrSize], uintptr(sp)); err != nil {
+			return true, err
+		}
+	}
+	return true, stepErr
+}
@@ -0,0 +1,12 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+import "golang.org/x/sys/unix"
+
+// mmap2 takes its arguments in registers, the old mmap a struct pointer
+const (
+	disasmMode = 32
+	sysMmap    = unix.SYS_MMAP2
+)
@@ -0,0 +1,11 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+import "golang.org/x/sys/unix"
+
+const (
+	disasmMode = 64
+	sysMmap    = unix.SYS_MMAP
+)
@@ -0,0 +1,79 @@
+package riptracer
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+func TestPrepareDisplaced(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       []byte
+		want       []byte
+		scratchReg string
+		absolute   bool
+		call       bool
+	}{
+		{"lock add rip", []byte{0xf0, 0x48, 0x83, 0x05, 0xe1, 0x2e, 0x00, 0x00, 0x01}, []byte{0xf0, 0x48, 0x83, 0x86, 0xe1, 0x2e, 0x00, 0x00, 0x01}, "rsi", false, false},
+		{"mov rsi rip", []byte{0x48, 0x8b, 0x35, 0x10, 0x00, 0x00, 0x00}, []byte{0x48, 0x8b, 0xb7, 0x10, 0x00, 0x00, 0x00}, "rdi", false, false},
+		{"mov r8 rip", []byte{0x4c, 0x8b, 0x05, 0x10, 0x00, 0x00, 0x00}, []byte{0x4c, 0x8b, 0x86, 0x10, 0x00, 0x00, 0x00}, "rsi", false, false},
+		{"call rel", []byte{0xe8, 0xe9, 0xff, 0xff, 0xff}, []byte{0xe8, 0xe9, 0xff, 0xff, 0xff}, "", false, true},
+		{"call rip", []byte{0xff, 0x15, 0x10, 0x00, 0x00, 0x00}, []byte{0xff, 0x96, 0x10, 0x00, 0x00, 0x00}, "rsi", true, true},
+		{"jne", []byte{0x75, 0xee}, []byte{0x75, 0xee}, "", false, false},
+		{"ret", []byte{0xc3, 0x90}, []byte{0xc3}, "", true, false},
+	}
+	for _, tt := range tests {
+		d, err := prepareDisplaced(tt.code, 64)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(d.code, tt.want) || d.scratchReg != tt.scratchReg || d.absolute != tt.absolute || d.call != tt.call {
+			t.Errorf("%s: got %x reg=%q absolute=%t call=%t, want %x reg=%q absolute=%t call=%t",
+				tt.name, d.code, d.scratchReg, d.absolute, d.call, tt.want, tt.scratchReg, tt.absolute, tt.call)
+		}
+	}
+}
+
+const displacedProgram = `
+#include <pthread.h>
+#include <stdio.h>
+#ifdef __x86_64__
+#define COUNTER "counter(%%rip)"
+#else
+#define COUNTER "counter"
+#endif
+int counter;
+static void *run(void *arg) {
+	for (int i = 0; i < 200; i++)
+		__asm__ volatile(".globl spot\n.type spot, @function\nspot: lock incl " COUNTER ::: "memory");
+	return 0;
+}
+int main(void) {
+	pthread_t threads[4];
+	for (int i = 0; i < 4; i++) pthread_create(&threads[i], 0, run, 0);
+	for (int i = 0; i < 4; i++) pthread_join(threads[i], 0);
+	printf("%d\n", counter);
+	return 0;
+}
+`
+
+func TestDisplacedStepThreads(t *testing.T) {
+	prog := compileTestProgram(t, displacedProgram, "-O0", "-pthread")
+
+	var stdout bytes.Buffer
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, Stdout: &stdout, StopAt: StopAtMain})
+	defer runtime.UnlockOSThread()
+	tracer.SetDisplacedStepping(true)
+	hits := 0
+	if err := tracer.Session().Root().SetBreakpointSymbol("spot", func(int, BreakPoint) { hits++ }); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+
+	// The breakpoint stays in place, no thread passes it unnoticed
+	if hits != 800 || stdout.String() != "800\n" {
+		t.Errorf("%d hits, output %q", hits, stdout.String())
+	}
+}
@@ -10,21 +10,21 @@ import (
 
 	"github.com/akamensky/argparse"
 	"github.com/caesurus/riptracer"
-	"golang.org/x/sys/unix"
 )
 
 var g_cnt = 0
 var g_serial []int32
 
 func CBKeyBreakPoint(pid int, bp riptracer.BreakPoint) {
-	var regs unix.PtraceRegs
-	err := unix.PtraceGetRegs(pid, &regs)
+	regs, err := riptracer.GetRegisters(pid)
 	if err != nil {
 		log.Fatalln("Error", err)
 	}
 
-	eax_ := int32(regs.Rax & 0xffffffff)
-	edx_ := int32(regs.Rdx & 0xffffffff)
+	eax, _ := regs.Get("eax")
+	edx, _ := regs.Get("edx")
+	eax_ := int32(eax)
+	edx_ := int32(edx)
 	fmt.Printf("*** eax(%6d) - edx(%6d)\n", eax_, edx_)
 	serial_char := eax_ - edx_
 	fmt.Printf("eax(%6d) - edx(%6d) = key[%d] %d\n", eax_, edx_, g_cnt, serial_char)
@@ -66,9 +66,13 @@ func main() {
 		tracer.EnableVerbose()
 	}
 	// Set a breakpoint at an absolute address
-	tracer.SetBreakpointAbsolute(uintptr(0x400fb8), CBKeyBreakPoint)
+	if err := tracer.SetBreakpointAbsolute(uintptr(0x400fb8), CBKeyBreakPoint); err != nil {
+		log.Fatalln(err)
+	}
 	// Set a breakpoint at a relative address (0x40115f)
-	tracer.SetBreakpointRelative(uintptr(0x115f), CBPrintSerialKey)
+	if err := tracer.SetBreakpointRelative(uintptr(0x115f), CBPrintSerialKey); err != nil {
+		log.Fatalln(err)
+	}
 	tracer.Start()
 
 }
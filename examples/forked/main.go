@@ -59,9 +59,11 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), riptracer.CBPrintRegisters)
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), riptracer.CBPrintStack)
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), CBHits)
+	for _, cb := range []riptracer.CallBackFunction{riptracer.CBPrintRegisters, riptracer.CBPrintStack, CBHits} {
+		if err := tracer.SetBreakpointRelative(uintptr(breakPointInt), cb); err != nil {
+			log.Fatalln(err)
+		}
+	}
 	tracer.SetFollowForks(true)
 
 	tracer.Start()
@@ -19,8 +19,11 @@ func check(err error) {
 }
 
 func ReadMem(pid int, addr uintptr, length int) []byte {
+	mem := riptracer.NewMemory(pid)
+	defer mem.Close()
+
 	data := make([]byte, length)
-	_, err := unix.PtracePeekData(pid, addr, data)
+	_, err := mem.ReadAt(data, addr)
 	if err != nil {
 		fmt.Printf("Error reading memory at 0x%012x\n", addr)
 	}
@@ -68,7 +71,9 @@ func main() {
 
 	tracer.SetFollowForks(true)
 
-	tracer.SetBreakpointRelative(0x560, CBFuncCalls)
+	if err := tracer.SetBreakpointRelative(0x560, CBFuncCalls); err != nil {
+		log.Fatalln(err)
+	}
 
 	tracer.Start()
 }
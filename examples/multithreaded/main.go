@@ -29,6 +29,7 @@ func main() {
 	var verbose *bool = parser.Flag("v", "verbose", &argparse.Options{Help: "Verbose Output"})
 	var breakPointStr *string = parser.String("b", "breakpoint", &argparse.Options{Required: true, Help: "Breakpoint in hex"})
 	var hwbreakPointStr *string = parser.String("w", "hwbreakpoint", &argparse.Options{Required: true, Help: "HWBreakpoint in hex"})
+	var displaced *bool = parser.Flag("d", "displaced", &argparse.Options{Help: "Step over breakpoints out of line"})
 
 	startCmd := parser.NewCommand("start", "Will start a process")
 	var cmd_str *string = startCmd.String("c", "cmd", &argparse.Options{Required: true, Help: "Cmd to execute"})
@@ -59,6 +60,7 @@ func main() {
 	if *verbose {
 		tracer.EnableVerbose()
 	}
+	tracer.SetDisplacedStepping(*displaced)
 
 	breakPointInt, err := strconv.ParseInt(*breakPointStr, 16, 64)
 	if err != nil {
@@ -70,13 +72,17 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), riptracer.CBPrintRegisters)
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), riptracer.CBPrintStack)
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), riptracer.CBFunctionArgs)
-	tracer.SetBreakpointRelative(uintptr(breakPointInt), CBHits)
+	for _, cb := range []riptracer.CallBackFunction{riptracer.CBPrintRegisters, riptracer.CBPrintStack, riptracer.CBFunctionArgs, CBHits} {
+		if err := tracer.SetBreakpointRelative(uintptr(breakPointInt), cb); err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-	tracer.SetHWBreakpointRelative(uintptr(hwbreakPointInt), CBHWHits)
-	tracer.SetHWBreakpointRelative(uintptr(hwbreakPointInt), riptracer.CBFunctionArgs)
+	for _, cb := range []riptracer.CallBackFunction{CBHWHits, riptracer.CBFunctionArgs} {
+		if err := tracer.SetHWBreakpointRelative(uintptr(hwbreakPointInt), cb); err != nil {
+			log.Fatalln(err)
+		}
+	}
 
 	tracer.Start()
 
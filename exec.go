@@ -0,0 +1,79 @@
+package riptracer
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// BreakpointSpec describes a breakpoint by executable and symbol or offset.
+// Unlike breakpoints, which an exec throws away with the old image, specs are
+// applied to every process running a matching executable, now and whenever a
+// process execs one. Wrapper scripts and launchers can be traced through this
+// way.
+type BreakpointSpec struct {
+	Exe      string  // Path or file name of the executable, empty for any
+	Symbol   string  // Function to break on
+	Offset   uintptr // Offset from the base address, used without Symbol
+	Callback CallBackFunction
+
+	exePath string // Exe with symlinks resolved
+}
+
+func (s *BreakpointSpec) matches(exe string) bool {
+	return s.Exe == "" || exe == s.Exe || exe == s.exePath || filepath.Base(exe) == s.Exe
+}
+
+func (s *BreakpointSpec) apply(p *TracedProcess) error {
+	if s.Symbol != "" {
+		return p.SetBreakpointSymbol(s.Symbol, s.Callback)
+	}
+	return p.SetBreakpointRelative(s.Offset, s.Callback)
+}
+
+func (s BreakpointSpec) String() string {
+	if s.Symbol != "" {
+		return fmt.Sprintf("%s:%s", s.Exe, s.Symbol)
+	}
+	return fmt.Sprintf("%s+0x%x", s.Exe, s.Offset)
+}
+
+// AddBreakpointSpec sets the breakpoint in all traced processes running the
+// executable and in every process that execs it later
+func (t *Tracer) AddBreakpointSpec(spec BreakpointSpec) error {
+	if spec.Callback == nil {
+		return fmt.Errorf("Breakpoint spec %s has no callback", spec)
+	}
+	if spec.Exe != "" {
+		if path, err := filepath.EvalSymlinks(spec.Exe); err == nil {
+			spec.exePath, _ = filepath.Abs(path)
+		}
+	}
+
+	for _, p := range t.session.Processes() {
+		if p.Exited || !spec.matches(p.Exe) {
+			continue
+		}
+		if err := spec.apply(p); err != nil {
+			return fmt.Errorf("Unable to set %s in %d: %v", spec, p.Pid, err)
+		}
+	}
+	t.breakpointSpecs = append(t.breakpointSpecs, spec)
+	return nil
+}
+
+// applyBreakpointSpecs arms the specs matching the executable of a process
+// that just execed
+func (t *Tracer) applyBreakpointSpecs(p *TracedProcess) {
+	for i := range t.breakpointSpecs {
+		spec := &t.breakpointSpecs[i]
+		if !spec.matches(p.Exe) {
+			continue
+		}
+		if err := spec.apply(p); err != nil {
+			log.Printf("Unable to set %s in %d: %v", spec, p.Pid, err)
+		} else if t.verbose {
+			log.Printf("Set %s in %d after exec", spec, p.Pid)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package riptracer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestBreakpointSpecMatches(t *testing.T) {
+	spec := BreakpointSpec{Exe: "child", exePath: "/opt/bin/child"}
+	for exe, want := range map[string]bool{
+		"/opt/bin/child":  true,
+		"/usr/bin/child":  true,
+		"/usr/bin/child2": false,
+		"":                false,
+	} {
+		if got := spec.matches(exe); got != want {
+			t.Errorf("matches(%q) = %t, want %t", exe, got, want)
+		}
+	}
+	if !(&BreakpointSpec{}).matches("/bin/sh") {
+		t.Error("Spec without Exe should match any executable")
+	}
+}
+
+// TestHelperExecer isn't a real test, it is started by TestExecRearmsSpecs as
+// a target that calls forkMark before and after execing itself
+func TestHelperExecer(t *testing.T) {
+	if os.Getenv("RIPTRACER_EXECER") == "" {
+		t.Skip("Helper process")
+	}
+	if os.Getenv("RIPTRACER_EXECED") != "" {
+		forkMark(2)
+		fmt.Print("ok")
+		os.Exit(0)
+	}
+	forkMark(1)
+	syscall.Exec(os.Args[0], os.Args, append(os.Environ(), "RIPTRACER_EXECED=1"))
+	os.Exit(2)
+}
+
+func TestExecRearmsSpecs(t *testing.T) {
+	defer runtime.UnlockOSThread()
+	var stdout bytes.Buffer
+	tracer := launchTestTracer(t, LaunchOptions{
+		Args:   []string{os.Args[0], "-test.run=^TestHelperExecer$"},
+		Env:    append(os.Environ(), "RIPTRACER_EXECER=1"),
+		Stdout: &stdout,
+	})
+	root := tracer.Session().Root()
+
+	// Test binaries have no symbols, the helper is this binary
+	self := newTestTracer(t, os.Getpid()).session.Root()
+	base, err := self.BaseAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := reflect.ValueOf(forkMark).Pointer() - base
+
+	specHits, plainHits := 0, 0
+	err = tracer.AddBreakpointSpec(BreakpointSpec{Exe: os.Args[0], Offset: offset, Callback: func(tid int, bp BreakPoint) {
+		specHits++
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Breakpoints set directly go away with the old image
+	if err := root.SetBreakpointRelative(offset, func(tid int, bp BreakPoint) { plainHits++ }); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+
+	if stdout.String() != "ok" {
+		t.Fatalf("Helper failed, output %q", stdout.String())
+	}
+	if specHits != 2 || plainHits != 1 {
+		t.Errorf("Spec hit %d times, plain breakpoint %d times, expected 2 and 1", specHits, plainHits)
+	}
+}
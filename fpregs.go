@@ -0,0 +1,297 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Register set holding the XSAVE area
+const NT_X86_XSTATE = 0x202
+
+// Offsets into the FXSAVE/XSAVE layout
+const (
+	fxsaveFCW      = 0
+	fxsaveFSW      = 2
+	fxsaveFTW      = 4
+	fxsaveFOP      = 6
+	fxsaveMXCSR    = 24
+	fxsaveST       = 32
+	fxsaveXMM      = 160
+	fxsaveSize     = 512
+	xsaveXstateBV  = 512
+	xsaveYMMHi     = 576
+	xstateYMMBit   = 1 << 2
+	maxXSaveSize   = 16384
+	x87RegSize     = 10
+	x87RegStride   = 16
+	vectorRegBytes = 16
+)
+
+// FPRegisters is the x87, SSE and (when available) AVX state of a thread
+type FPRegisters struct {
+	Tid   int
+	data  []byte
+	note  int
+	xsave bool
+}
+
+// GetFPRegisters reads the floating point and vector state of a stopped
+// thread, using the XSAVE layout when the kernel provides it. The buffer has
+// to hold the complete XSAVE area, SETREGSET rejects partial ones.
+func GetFPRegisters(tid int) (*FPRegisters, error) {
+	buf := make([]byte, maxXSaveSize)
+	n, err := ptraceGetRegSet(tid, NT_X86_XSTATE, buf)
+	if err == nil && n >= xsaveYMMHi {
+		return &FPRegisters{Tid: tid, data: buf[:n], note: NT_X86_XSTATE, xsave: true}, nil
+	}
+
+	n, err = ptraceGetRegSet(tid, fxsaveNote, buf[:fxsaveSize])
+	if err != nil {
+		return nil, err
+	}
+	return &FPRegisters{Tid: tid, data: buf[:n], note: fxsaveNote}, nil
+}
+
+// SetFPRegisters writes back a (modified) state read with GetFPRegisters
+func SetFPRegisters(tid int, f *FPRegisters) error {
+	return ptraceSetRegSet(tid, f.note, f.data)
+}
+
+// HasAVX reports whether the YMM upper halves are part of this state
+func (f *FPRegisters) HasAVX() bool {
+	return f.xsave && len(f.data) >= xsaveYMMHi+numXMMRegisters*vectorRegBytes
+}
+
+func (f *FPRegisters) MXCSR() uint32 {
+	return binary.LittleEndian.Uint32(f.data[fxsaveMXCSR:])
+}
+
+// scalarFPRegister returns the offset and size of a named control register
+func scalarFPRegister(name string) (int, int, bool) {
+	switch name {
+	case "fcw":
+		return fxsaveFCW, 2, true
+	case "fsw":
+		return fxsaveFSW, 2, true
+	case "ftw":
+		return fxsaveFTW, 1, true
+	case "fop":
+		return fxsaveFOP, 2, true
+	case "mxcsr":
+		return fxsaveMXCSR, 4, true
+	}
+	return 0, 0, false
+}
+
+// parseVectorName splits names such as "xmm3" into kind and index
+func parseVectorName(name string) (string, int, error) {
+	name = strings.ToLower(name)
+	for _, kind := range []string{"xmm", "ymm", "st"} {
+		if strings.HasPrefix(name, kind) {
+			idx, err := strconv.Atoi(name[len(kind):])
+			if err != nil {
+				break
+			}
+			limit := numXMMRegisters
+			if kind == "st" {
+				limit = 8
+			}
+			if idx < 0 || idx >= limit {
+				return "", 0, fmt.Errorf("Register index out of range: %s", name)
+			}
+			return kind, idx, nil
+		}
+	}
+	return "", 0, fmt.Errorf("Unknown vector register %s", name)
+}
+
+// Get returns the raw little endian bytes of st0-7 (10 bytes), xmm (16 bytes),
+// ymm (32 bytes) or one of the control registers fcw, fsw, ftw, fop, mxcsr
+func (f *FPRegisters) Get(name string) ([]byte, error) {
+	if off, size, ok := scalarFPRegister(strings.ToLower(name)); ok {
+		return append([]byte{}, f.data[off:off+size]...), nil
+	}
+
+	kind, idx, err := parseVectorName(name)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "st":
+		off := fxsaveST + idx*x87RegStride
+		return append([]byte{}, f.data[off:off+x87RegSize]...), nil
+	case "xmm":
+		off := fxsaveXMM + idx*vectorRegBytes
+		return append([]byte{}, f.data[off:off+vectorRegBytes]...), nil
+	default:
+		if !f.HasAVX() {
+			return nil, fmt.Errorf("AVX state not available")
+		}
+		value := make([]byte, 0, 2*vectorRegBytes)
+		value = append(value, f.data[fxsaveXMM+idx*vectorRegBytes:fxsaveXMM+(idx+1)*vectorRegBytes]...)
+		if binary.LittleEndian.Uint64(f.data[xsaveXstateBV:])&xstateYMMBit == 0 {
+			// Upper halves are in their initial (zero) state
+			return append(value, make([]byte, vectorRegBytes)...), nil
+		}
+		return append(value, f.data[xsaveYMMHi+idx*vectorRegBytes:xsaveYMMHi+(idx+1)*vectorRegBytes]...), nil
+	}
+}
+
+// Set updates a register in the state, call SetFPRegisters to apply it
+func (f *FPRegisters) Set(name string, value []byte) error {
+	current, err := f.Get(name)
+	if err != nil {
+		return err
+	}
+	if len(value) > len(current) {
+		return fmt.Errorf("Value too large for %s: %d bytes", name, len(value))
+	}
+	// Shorter values are zero extended
+	copy(current, make([]byte, len(current)))
+	copy(current, value)
+
+	if off, _, ok := scalarFPRegister(strings.ToLower(name)); ok {
+		copy(f.data[off:], current)
+		return nil
+	}
+
+	kind, idx, _ := parseVectorName(name)
+	switch kind {
+	case "st":
+		copy(f.data[fxsaveST+idx*x87RegStride:], current)
+	case "xmm":
+		copy(f.data[fxsaveXMM+idx*vectorRegBytes:], current)
+	default:
+		copy(f.data[fxsaveXMM+idx*vectorRegBytes:], current[:vectorRegBytes])
+		copy(f.data[xsaveYMMHi+idx*vectorRegBytes:], current[vectorRegBytes:])
+		bv := binary.LittleEndian.Uint64(f.data[xsaveXstateBV:])
+		binary.LittleEndian.PutUint64(f.data[xsaveXstateBV:], bv|xstateYMMBit)
+	}
+	return nil
+}
+
+// Float64 interprets st0-7 as 80 bit extended precision and xmm/ymm as the
+// double in their low 64 bits
+func (f *FPRegisters) Float64(name string) (float64, error) {
+	value, err := f.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) == x87RegSize {
+		return float80ToFloat64(value), nil
+	}
+	if len(value) < 8 {
+		return 0, fmt.Errorf("%s isn't a floating point register", name)
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(value)), nil
+}
+
+// Float32 returns the float in the low 32 bits of an xmm/ymm register
+func (f *FPRegisters) Float32(name string) (float32, error) {
+	value, err := f.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) < vectorRegBytes {
+		return 0, fmt.Errorf("%s isn't a vector register", name)
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(value)), nil
+}
+
+func float80ToFloat64(b []byte) float64 {
+	mantissa := binary.LittleEndian.Uint64(b)
+	se := binary.LittleEndian.Uint16(b[8:])
+	sign := se&0x8000 != 0
+	exp := int(se & 0x7fff)
+
+	var v float64
+	switch {
+	case exp == 0 && mantissa == 0:
+		v = 0
+	case exp == 0x7fff:
+		if mantissa<<1 == 0 {
+			v = math.Inf(1)
+		} else {
+			return math.NaN()
+		}
+	default:
+		v = math.Ldexp(float64(mantissa), exp-16383-63)
+	}
+	if sign {
+		v = -v
+	}
+	return v
+}
+
+func (f *FPRegisters) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s   mxcsr:%s 0x%08x%s\n", Blue, Green, f.MXCSR(), Reset)
+	for i := 0; i < 8; i++ {
+		v, _ := f.Float64(fmt.Sprintf("st%d", i))
+		fmt.Fprintf(&sb, "%s%8s:%s %g%s\n", Blue, fmt.Sprintf("st%d", i), Green, v, Reset)
+	}
+	for i := 0; i < numXMMRegisters; i++ {
+		name := fmt.Sprintf("xmm%d", i)
+		if f.HasAVX() {
+			name = fmt.Sprintf("ymm%d", i)
+		}
+		value, _ := f.Get(name)
+		d, _ := f.Float64(name)
+		fmt.Fprintf(&sb, "%s%8s:%s %s (%g)%s\n", Blue, name, Green, vectorHex(value), d, Reset)
+	}
+	return sb.String()
+}
+
+// vectorHex prints a vector register most significant byte first
+func vectorHex(value []byte) string {
+	var sb strings.Builder
+	sb.WriteString("0x")
+	for i := len(value) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%02x", value[i])
+	}
+	return sb.String()
+}
+
+// GetVectorReg reads xmm, ymm or st registers of a stopped thread
+func GetVectorReg(tid int, name string) ([]byte, error) {
+	f, err := GetFPRegisters(tid)
+	if err != nil {
+		return nil, err
+	}
+	return f.Get(name)
+}
+
+// SetVectorReg writes xmm, ymm or st registers of a stopped thread
+func SetVectorReg(tid int, name string, value []byte) error {
+	f, err := GetFPRegisters(tid)
+	if err != nil {
+		return err
+	}
+	if err := f.Set(name, value); err != nil {
+		return err
+	}
+	return SetFPRegisters(tid, f)
+}
+
+func CBPrintRegistersExtended(pid int, bp BreakPoint) {
+	fmt.Println(Blue, "----------REGS----------", Reset)
+	regs, err := GetRegisters(pid)
+	check(err)
+	fmt.Print(regs)
+
+	f, err := GetFPRegisters(pid)
+	if err != nil {
+		fmt.Printf("%sUnable to read FP registers: %v%s\n", Red, err, Reset)
+		return
+	}
+	fmt.Print(f)
+}
+
+func littleEndianValue(b []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], b)
+	return binary.LittleEndian.Uint64(buf[:])
+}
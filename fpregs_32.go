@@ -0,0 +1,10 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+// Register set holding the FXSAVE area. On i386 NT_PRFPREG is the legacy
+// user_i387_struct, the FXSAVE layout is NT_PRXFPREG.
+const fxsaveNote = 0x46e62b7f
+
+const numXMMRegisters = 8
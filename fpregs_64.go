@@ -0,0 +1,9 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+// Register set holding the FXSAVE area, NT_PRFPREG on x86_64
+const fxsaveNote = 2
+
+const numXMMRegisters = 16
@@ -0,0 +1,72 @@
+package riptracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFloat80ToFloat64(t *testing.T) {
+	tests := []struct {
+		mantissa uint64
+		se       uint16
+		want     float64
+	}{
+		{0, 0, 0},
+		{1 << 63, 16383, 1},
+		{1 << 63, 16383 | 0x8000, -1},
+		{3 << 62, 16384, 3},
+		{0xc90fdaa22168c235, 16384, math.Pi},
+		{1 << 63, 0x7fff, math.Inf(1)},
+	}
+	for _, tt := range tests {
+		b := make([]byte, 10)
+		binary.LittleEndian.PutUint64(b, tt.mantissa)
+		binary.LittleEndian.PutUint16(b[8:], tt.se)
+		if got := float80ToFloat64(b); got != tt.want {
+			t.Errorf("float80ToFloat64(%x, %x) = %v, want %v", tt.mantissa, tt.se, got, tt.want)
+		}
+	}
+}
+
+func TestFPRegistersGetSet(t *testing.T) {
+	f := &FPRegisters{data: make([]byte, xsaveYMMHi+numXMMRegisters*vectorRegBytes), xsave: true}
+
+	if _, err := f.Get("xmm99"); err == nil {
+		t.Errorf("Get(xmm99) should fail")
+	}
+
+	d := make([]byte, 8)
+	binary.LittleEndian.PutUint64(d, math.Float64bits(2.5))
+	if err := f.Set("xmm1", d); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := f.Float64("xmm1"); v != 2.5 {
+		t.Errorf("Float64(xmm1) = %v, want 2.5", v)
+	}
+	if !bytes.Equal(f.data[fxsaveXMM+16:fxsaveXMM+24], d) {
+		t.Errorf("xmm1 not stored at FXSAVE offset")
+	}
+
+	ymm := bytes.Repeat([]byte{0xaa}, 32)
+	if err := f.Set("ymm2", ymm); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := f.Get("ymm2"); !bytes.Equal(got, ymm) {
+		t.Errorf("Get(ymm2) = %x, want %x", got, ymm)
+	}
+	if got, _ := f.Get("xmm2"); !bytes.Equal(got, ymm[:16]) {
+		t.Errorf("xmm2 should alias the low half of ymm2")
+	}
+
+	if err := f.Set("mxcsr", []byte{0x80, 0x1f}); err != nil {
+		t.Fatal(err)
+	}
+	if f.MXCSR() != 0x1f80 {
+		t.Errorf("MXCSR() = %x, want 1f80", f.MXCSR())
+	}
+	if err := f.Set("fcw", make([]byte, 4)); err == nil {
+		t.Errorf("Set(fcw) with 4 bytes should fail")
+	}
+}
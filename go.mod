@@ -4,6 +4,7 @@ go 1.19
 
 require (
 	github.com/prometheus/procfs v0.9.0
+	golang.org/x/arch v0.11.0
 	golang.org/x/sys v0.4.0
 )
 
@@ -0,0 +1,54 @@
+package riptracer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// skipWithoutPtrace skips the test if launching failed because ptrace isn't
+// permitted here, any other error fails it
+func skipWithoutPtrace(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, unix.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestTracer returns a tracer for a process that is already traced
+func newTestTracer(t *testing.T, pid int) *Tracer {
+	procFS, err := procfs.NewFS("/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &Tracer{ProcFS: procFS, resolvers: make(map[string]*SymbolResolver), memory: make(map[int]*Memory), threads: make(map[int]*Thread)}
+	tracer.session = newSession(tracer, pid)
+	return tracer
+}
+
+// compileTestProgram builds a C program with cc and returns its path, the
+// test is skipped if that isn't possible
+func compileTestProgram(t *testing.T, source string, flags ...string) string {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prog.c")
+	if err := os.WriteFile(src, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := append(flags, "-o", filepath.Join(dir, "prog"), src)
+	if runtime.GOARCH == "386" {
+		args = append(args, "-m32")
+	}
+	if out, err := exec.Command("cc", args...).CombinedOutput(); err != nil {
+		t.Skipf("Unable to compile the target: %v %s", err, out)
+	}
+	return filepath.Join(dir, "prog")
+}
@@ -0,0 +1,41 @@
+package riptracer
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// syscallAddress returns the address of a system call instruction in the code
+// of the process of tid, preferably in the vdso. Injected system calls run it
+// instead of changing memory other threads may be executing.
+func (t *Tracer) syscallAddress(tid int) (uintptr, error) {
+	proc := t.processOf(tid)
+	mem := t.Memory(tid)
+	found := make([]byte, len(syscallInsn))
+	if addr := proc.space.syscall; addr != 0 {
+		// The mapping may be gone
+		if _, err := mem.ReadAt(found, addr); err == nil && bytes.Equal(found, syscallInsn) {
+			return addr, nil
+		}
+	}
+
+	procMaps, err := proc.MemMaps()
+	if err != nil {
+		return 0, err
+	}
+	for _, vdso := range []bool{true, false} {
+		for _, m := range procMaps {
+			if m.Perms == nil || !m.Perms.Execute || (m.Pathname == "[vdso]") != vdso || m.Pathname == "[vsyscall]" {
+				continue
+			}
+			code := make([]byte, m.EndAddr-m.StartAddr)
+			n, _ := mem.ReadAt(code, m.StartAddr)
+			// The bytes don't have to be an instruction of the code around them
+			if idx := bytes.Index(code[:n], syscallInsn); idx >= 0 {
+				proc.space.syscall = m.StartAddr + uintptr(idx)
+				return proc.space.syscall, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("No system call instruction in process %d", proc.Pid)
+}
@@ -0,0 +1,77 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offset of si_addr in siginfo_t
+const siginfoAddrOffset = 12
+
+var syscallInsn = []byte{0xcd, 0x80}
+
+// injectSyscall makes the stopped thread tid execute a system call and returns
+// its result. Memory isn't changed, the thread runs a system call instruction
+// found in its process, so other threads can keep running.
+func (t *Tracer) injectSyscall(tid int, nr uintptr, args ...uint64) (uint64, error) {
+	var saved unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &saved); err != nil {
+		return 0, err
+	}
+
+	regs := saved
+	setSyscallArgs(&regs, nr, args...)
+	pc, err := t.syscallAddress(tid)
+	if err != nil {
+		return 0, err
+	}
+	ret, err := t.runInjected(tid, pc, &regs, &saved)
+	if err != nil {
+		return 0, err
+	}
+	if int32(ret) < 0 && int32(ret) > -4096 {
+		return ret, unix.Errno(-int32(ret))
+	}
+	return ret, nil
+}
+
+// setSyscallArgs loads the number and arguments of a system call into regs
+func setSyscallArgs(regs *unix.PtraceRegs, nr uintptr, args ...uint64) {
+	argRegs := []*int32{&regs.Ebx, &regs.Ecx, &regs.Edx, &regs.Esi, &regs.Edi, &regs.Ebp}
+	for i := range args {
+		*argRegs[i] = int32(args[i])
+	}
+	regs.Eax = int32(nr)
+	// Don't let the kernel treat this as a restarted system call
+	regs.Orig_eax = -1
+}
+
+func (t *Tracer) runInjected(tid int, pc uintptr, regs *unix.PtraceRegs, saved *unix.PtraceRegs) (uint64, error) {
+	defer unix.PtraceSetRegs(tid, saved)
+
+	regs.Eip = int32(pc)
+	if err := unix.PtraceSetRegs(tid, regs); err != nil {
+		return 0, err
+	}
+	if err := t.stepThread(tid); err != nil {
+		return 0, err
+	}
+
+	var result unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &result); err != nil {
+		return 0, err
+	}
+	if uintptr(uint32(result.Eip)) != pc+uintptr(len(syscallInsn)) {
+		return 0, fmt.Errorf("Injected syscall didn't complete, eip 0x%x", result.Eip)
+	}
+	return uint64(uint32(result.Eax)), nil
+}
+
+func siginfoAddr(info []byte) uintptr {
+	return uintptr(binary.LittleEndian.Uint32(info[siginfoAddrOffset:]))
+}
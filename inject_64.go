@@ -0,0 +1,77 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offset of si_addr in siginfo_t
+const siginfoAddrOffset = 16
+
+var syscallInsn = []byte{0x0f, 0x05}
+
+// injectSyscall makes the stopped thread tid execute a system call and returns
+// its result. Memory isn't changed, the thread runs a system call instruction
+// found in its process, so other threads can keep running.
+func (t *Tracer) injectSyscall(tid int, nr uintptr, args ...uint64) (uint64, error) {
+	var saved unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &saved); err != nil {
+		return 0, err
+	}
+
+	regs := saved
+	setSyscallArgs(&regs, nr, args...)
+	pc, err := t.syscallAddress(tid)
+	if err != nil {
+		return 0, err
+	}
+	ret, err := t.runInjected(tid, pc, &regs, &saved)
+	if err != nil {
+		return 0, err
+	}
+	if int64(ret) < 0 && int64(ret) > -4096 {
+		return ret, unix.Errno(-int64(ret))
+	}
+	return ret, nil
+}
+
+// setSyscallArgs loads the number and arguments of a system call into regs
+func setSyscallArgs(regs *unix.PtraceRegs, nr uintptr, args ...uint64) {
+	argRegs := []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9}
+	for i := range args {
+		*argRegs[i] = args[i]
+	}
+	regs.Rax = uint64(nr)
+	// Don't let the kernel treat this as a restarted system call
+	regs.Orig_rax = ^uint64(0)
+}
+
+func (t *Tracer) runInjected(tid int, pc uintptr, regs *unix.PtraceRegs, saved *unix.PtraceRegs) (uint64, error) {
+	defer unix.PtraceSetRegs(tid, saved)
+
+	regs.Rip = uint64(pc)
+	if err := unix.PtraceSetRegs(tid, regs); err != nil {
+		return 0, err
+	}
+	if err := t.stepThread(tid); err != nil {
+		return 0, err
+	}
+
+	var result unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &result); err != nil {
+		return 0, err
+	}
+	if uintptr(result.Rip) != pc+uintptr(len(syscallInsn)) {
+		return 0, fmt.Errorf("Injected syscall didn't complete, rip 0x%x", result.Rip)
+	}
+	return result.Rax, nil
+}
+
+func siginfoAddr(info []byte) uintptr {
+	return uintptr(binary.LittleEndian.Uint64(info[siginfoAddrOffset:]))
+}
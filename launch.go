@@ -0,0 +1,281 @@
+package riptracer
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How long Start waits for the output of a launched process to be copied
+// after it exits, children it left running may keep the pipes open
+const launchWaitDelay = time.Second
+
+// LaunchOptions describes how NewTracerLaunch starts a process. Stdio that
+// isn't an *os.File is copied through a pipe, nil means /dev/null as with
+// exec.Cmd.
+type LaunchOptions struct {
+	Args    []string // Program and its arguments, the program is looked up in PATH
+	Env     []string // Environment as key=value, nil to inherit ours
+	Dir     string   // Working directory, empty to inherit ours
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Rlimits map[int]unix.Rlimit // Resource limits by unix.RLIMIT_*, set before the program is loaded
+	// Credential drops to another user and group, requires the privileges to
+	// switch to them
+	Credential      *syscall.Credential
+	NewProcessGroup bool // Run in its own process group, e.g. to not get our SIGINT
+	DisableASLR     bool // Load at the same addresses on every run
+	StopAt          LaunchStop
+}
+
+// LaunchStop is where a launched process waits for Start
+type LaunchStop int
+
+const (
+	StopAtExec  LaunchStop = iota // Right after exec, only the dynamic loader is mapped
+	StopAtEntry                   // Entry point of the executable, libraries are loaded
+	StopAtMain                    // Function main of the executable
+)
+
+func (o *LaunchOptions) command() (*exec.Cmd, error) {
+	if len(o.Args) == 0 || o.Args[0] == "" {
+		return nil, fmt.Errorf("No program to launch")
+	}
+	cmd := exec.Command(o.Args[0], o.Args[1:]...)
+	cmd.Env = o.Env
+	cmd.Dir = o.Dir
+	cmd.Stdin = o.Stdin
+	cmd.Stdout = o.Stdout
+	cmd.Stderr = o.Stderr
+	cmd.WaitDelay = launchWaitDelay
+	cmd.SysProcAttr = &unix.SysProcAttr{
+		Ptrace:     true,
+		Credential: o.Credential,
+		Setpgid:    o.NewProcessGroup,
+	}
+	return cmd, nil
+}
+
+// launchTraced starts the process and leaves it seized and stopped on its
+// first instruction
+func launchTraced(opts LaunchOptions) (*exec.Cmd, error) {
+	cmd, err := opts.command()
+	if err != nil {
+		return nil, err
+	}
+	var persona uintptr
+	if opts.DisableASLR {
+		persona |= addrNoRandomize
+	}
+	if err := startWithPersonality(cmd, persona); err != nil {
+		return nil, err
+	}
+
+	// The exec stops with a SIGTRAP. cmd.Wait can't be used, it waits for the
+	// stdio pipes as well.
+	pid := cmd.Process.Pid
+	var ws unix.WaitStatus
+	if _, err := unix.Wait4(pid, &ws, unix.WALL, nil); err != nil {
+		return nil, err
+	}
+	if !ws.Stopped() {
+		cmd.Wait()
+		return nil, fmt.Errorf("Process %d exited before it was traced: %v", pid, ws)
+	}
+
+	fail := func(err error) (*exec.Cmd, error) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	for resource, limit := range opts.Rlimits {
+		limit := limit
+		if err := unix.Prlimit(pid, resource, &limit, nil); err != nil {
+			return fail(fmt.Errorf("Unable to set resource limit %d of %d: %v", resource, pid, err))
+		}
+	}
+	if len(opts.Rlimits) > 0 {
+		// Limits like the stack size shape the image as exec loads it
+		if err := reexec(pid); err != nil {
+			return fail(fmt.Errorf("Unable to apply resource limits to %d: %v", pid, err))
+		}
+	}
+
+	if err := seizeTracedChild(pid); err != nil {
+		return fail(err)
+	}
+	return cmd, nil
+}
+
+// reexec makes a process stopped right after exec run the same exec again.
+// The arguments and environment are still on its stack, the file name is
+// found through the auxiliary vector.
+func reexec(pid int) error {
+	var regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	auxv, err := readAuxv(pid)
+	if err != nil {
+		return err
+	}
+	pc, _ := readRegister(&regs, "pc")
+	sp, _ := readRegister(&regs, "sp")
+	argc := make([]byte, ptrSize)
+	if _, err := unix.PtracePeekData(pid, uintptr(sp), argc); err != nil {
+		return err
+	}
+	argv := sp + uint64(ptrSize)
+	envp := argv + (littleEndianValue(argc)+1)*uint64(ptrSize)
+	setSyscallArgs(&regs, unix.SYS_EXECVE, auxv[auxvExecFn], argv, envp)
+
+	// A failed exec returns to the int3
+	code := append(append([]byte{}, syscallInsn...), 0xCC)
+	if _, err := unix.PtracePokeData(pid, uintptr(pc), code); err != nil {
+		return err
+	}
+	if err := unix.PtraceSetRegs(pid, &regs); err != nil {
+		return err
+	}
+	if err := unix.PtraceCont(pid, 0); err != nil {
+		return err
+	}
+	var ws unix.WaitStatus
+	if _, err := unix.Wait4(pid, &ws, unix.WALL, nil); err != nil {
+		return err
+	}
+	if !ws.Stopped() || ws.StopSignal() != unix.SIGTRAP {
+		return fmt.Errorf("Unexpected status %v", ws)
+	}
+	if err := unix.PtraceGetRegs(pid, &regs); err != nil {
+		return err
+	}
+	if next, _ := readRegister(&regs, "pc"); next == pc+uint64(len(code)) {
+		ret, _ := readRegister(&regs, "eax")
+		return fmt.Errorf("Exec failed: %v", unix.Errno(-int32(ret)))
+	}
+	return nil
+}
+
+// waitLaunched waits for the output of a launched process to be copied once
+// tracing ends. The process has been reaped by then, only the error of the
+// copy is of interest.
+func (t *Tracer) waitLaunched() {
+	if t.cmd == nil {
+		return
+	}
+	t.cmd.Wait()
+	t.cmd = nil
+}
+
+// stopAt runs the launched process to where it should wait for Start
+func (t *Tracer) stopAt(stop LaunchStop) error {
+	root := t.session.Root()
+	var addr uintptr
+	switch stop {
+	case StopAtExec:
+		return nil
+	case StopAtEntry:
+		auxv, err := readAuxv(root.Pid)
+		if err != nil {
+			return err
+		}
+		if addr = uintptr(auxv[auxvEntry]); addr == 0 {
+			return fmt.Errorf("No entry point in the auxiliary vector of %d", root.Pid)
+		}
+	case StopAtMain:
+		var err error
+		if addr, err = root.SymbolAddress("main"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown launch stop %d", stop)
+	}
+	return t.runTo(root.Pid, addr)
+}
+
+// runTo continues the process until pid executes addr, it is left stopped
+// there with the original code in place. Threads started meanwhile are
+// registered like Start does and stopped as well.
+func (t *Tracer) runTo(pid int, addr uintptr) error {
+	mem := t.Memory(pid)
+	org := make([]byte, 1)
+	if _, err := mem.ReadAt(org, addr); err != nil {
+		return err
+	}
+	if _, err := mem.WriteAt([]byte{0xCC}, addr); err != nil {
+		return err
+	}
+	defer mem.WriteAt(org, addr)
+
+	if err := unix.PtraceCont(pid, 0); err != nil {
+		return err
+	}
+	for {
+		var ws unix.WaitStatus
+		wpid, err := unix.Wait4(-1, &ws, unix.WALL, nil)
+		if err != nil {
+			return err
+		}
+		if ws.Exited() || ws.Signaled() {
+			t.removeThread(wpid)
+			if wpid == pid {
+				return fmt.Errorf("Process %d exited before reaching 0x%x", pid, addr)
+			}
+			continue
+		}
+		t.addThread(wpid, 0)
+
+		sig := 0
+		switch {
+		case uint32(ws)>>8 == uint32(unix.SIGTRAP)|unix.PTRACE_EVENT_CLONE<<8:
+			// A constructor starting a thread
+			if tid, err := unix.PtraceGetEventMsg(wpid); err == nil {
+				t.addThread(int(tid), wpid).Parent = wpid
+			}
+		case isEventStop(ws) && ws.StopSignal() != unix.SIGTRAP:
+			// Group-stop, stay stopped until SIGCONT
+			if err := ptraceListen(wpid); err != nil {
+				return err
+			}
+			continue
+		case uint32(ws)>>16 != 0:
+			// First stop of a new thread
+		case ws.StopSignal() != unix.SIGTRAP:
+			sig = int(ws.StopSignal())
+		default:
+			pc, err := GetReg(wpid, "pc")
+			if err != nil {
+				return err
+			}
+			if uintptr(pc)-1 != addr {
+				sig = int(unix.SIGTRAP)
+				break
+			}
+			if err := SetReg(wpid, "pc", uint64(addr)); err != nil {
+				return err
+			}
+			if wpid == pid {
+				t.stopThreads(pid)
+				return nil
+			}
+			// Only pid stops here, others step over the original code
+			mem.WriteAt(org, addr)
+			err = t.stepThread(wpid)
+			if err != errStepExec {
+				mem.WriteAt([]byte{0xCC}, addr)
+			}
+			if leftToMainLoop(err) {
+				continue
+			}
+		}
+		if err := t.continueThread(wpid, sig); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package riptracer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLaunchTraced(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var stdout bytes.Buffer
+	dir := t.TempDir()
+	script := `echo "$1"; echo "$FOO"; pwd; ulimit -n; cut -d" " -f5 /proc/$$/stat; echo $$`
+	cmd, err := launchTraced(LaunchOptions{
+		Args:            []string{"/bin/sh", "-c", script, "sh", "two  words"},
+		Env:             []string{"FOO=bar"},
+		Dir:             dir,
+		Stdout:          &stdout,
+		Rlimits:         map[int]unix.Rlimit{unix.RLIMIT_NOFILE: {Cur: 64, Max: 64}},
+		NewProcessGroup: true,
+	})
+	skipWithoutPtrace(t, err)
+	if err := unix.PtraceDetach(cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := fmt.Sprint(cmd.Process.Pid)
+	want := []string{"two  words", "bar", dir, "64", pid, pid}
+	if got := strings.Split(strings.TrimSpace(stdout.String()), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Got output %q, want %q", got, want)
+	}
+}
+
+func TestLaunchMissingProgram(t *testing.T) {
+	if _, err := launchTraced(LaunchOptions{Args: []string{"/nonexistent/program"}}); !os.IsNotExist(err) {
+		t.Errorf("Launching a missing program returned %v", err)
+	}
+	if _, err := launchTraced(LaunchOptions{}); err == nil {
+		t.Error("Launching without arguments succeeded")
+	}
+}
+
+func TestLaunchDisableASLR(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	for _, disable := range []bool{true, false} {
+		cmd, err := launchTraced(LaunchOptions{Args: []string{"/bin/true"}, DisableASLR: disable})
+		skipWithoutPtrace(t, err)
+		randomized, err := (&TracedProcess{Pid: cmd.Process.Pid}).Randomized()
+		unix.PtraceDetach(cmd.Process.Pid)
+		cmd.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disable && randomized {
+			t.Error("Process launched with DisableASLR is randomized")
+		}
+	}
+
+	// Our own personality is left alone
+	if persona, err := personality(personalityQuery); err != nil || persona&addrNoRandomize != 0 {
+		t.Errorf("Personality 0x%x after launch: %v", persona, err)
+	}
+}
+
+func TestLaunchStopAtEntry(t *testing.T) {
+	tracer, err := NewTracerLaunch(LaunchOptions{Args: []string{"/bin/true"}, StopAt: StopAtEntry})
+	skipWithoutPtrace(t, err)
+	defer runtime.UnlockOSThread()
+	defer tracer.cmd.Wait()
+	defer tracer.Process.Kill()
+
+	auxv, err := readAuxv(tracer.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := GetReg(tracer.Process.Pid, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc != auxv[auxvEntry] {
+		t.Errorf("Stopped at 0x%x, entry point is 0x%x", pc, auxv[auxvEntry])
+	}
+}
+
+func TestLaunchStackLimit(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// The stack limit decides where the kernel maps the dynamic loader
+	const limit = 512 << 20
+	var ours unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_STACK, &ours); err != nil || ours.Max < limit {
+		t.Skipf("Stack limit %d: %v", ours.Max, err)
+	}
+	cmd, err := launchTraced(LaunchOptions{
+		Args:        []string{"/bin/true"},
+		Rlimits:     map[int]unix.Rlimit{unix.RLIMIT_STACK: {Cur: limit, Max: ours.Max}},
+		DisableASLR: true,
+	})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	procMaps, err := newTestTracer(t, cmd.Process.Pid).session.Root().MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stack, below uintptr
+	for _, m := range procMaps {
+		if m.Pathname == "[stack]" {
+			stack = m.StartAddr
+		}
+	}
+	for _, m := range procMaps {
+		if m.EndAddr <= stack && m.EndAddr > below {
+			below = m.EndAddr
+		}
+	}
+	if stack-below < limit {
+		t.Errorf("Mappings end 0x%x below the stack at 0x%x, expected at least 0x%x", stack-below, stack, limit)
+	}
+}
+
+// Starts a thread from a constructor, it runs until main
+const stopAtMainProgram = `
+#include <pthread.h>
+#include <unistd.h>
+static volatile int done;
+static pthread_t th;
+static void *run(void *arg) { while (!done) usleep(1000); return 0; }
+__attribute__((constructor)) static void init(void) { pthread_create(&th, 0, run, 0); }
+int main(void) { done = 1; pthread_join(th, 0); return 0; }
+`
+
+func TestLaunchStopAtMain(t *testing.T) {
+	prog := compileTestProgram(t, stopAtMainProgram, "-pthread")
+	tracer, err := NewTracerLaunch(LaunchOptions{Args: []string{prog}, StopAt: StopAtMain})
+	skipWithoutPtrace(t, err)
+	defer runtime.UnlockOSThread()
+	defer tracer.Process.Kill()
+
+	root := tracer.Session().Root()
+	main, err := root.SymbolAddress("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc, err := GetReg(root.Pid, "pc"); err != nil || uintptr(pc) != main {
+		t.Errorf("Stopped at 0x%x, main is at 0x%x: %v", pc, main, err)
+	}
+	if len(tracer.threads) != 2 {
+		t.Errorf("Threads %v after the constructor, expected 2", tracer.threadIDs())
+	}
+	for tid := range tracer.threads {
+		if state, err := waitState(tid, "t"); state != "t" {
+			t.Errorf("Thread %d in state %s: %v", tid, state, err)
+		}
+	}
+	tracer.Start()
+}
@@ -0,0 +1,145 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	addrNoRandomize  = 0x0040000
+	personalityQuery = 0xffffffff
+)
+
+func personality(persona uintptr) (uintptr, error) {
+	r, _, errno := unix.RawSyscall(unix.SYS_PERSONALITY, persona, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// startWithPersonality starts cmd with flags added to our personality. It is
+// inherited on fork and applied on exec, setting it on our thread for the
+// fork leaves the rest of the system alone.
+func startWithPersonality(cmd *exec.Cmd, flags uintptr) error {
+	if flags == 0 {
+		return cmd.Start()
+	}
+	// The child is forked from this thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	old, err := personality(personalityQuery)
+	if err != nil {
+		return err
+	}
+	if _, err := personality(old | flags); err != nil {
+		return fmt.Errorf("Unable to set personality 0x%x: %v", old|flags, err)
+	}
+	defer personality(old)
+	return cmd.Start()
+}
+
+// Auxiliary vector entries with the program headers, entry point and path of
+// the executable
+const (
+	auxvPhdr   = 3
+	auxvEntry  = 9
+	auxvExecFn = 31 // File name passed to exec
+)
+
+// readAuxv returns the auxiliary vector the kernel passed to the program
+func readAuxv(pid int) (map[uint64]uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/auxv", pid))
+	if err != nil {
+		return nil, err
+	}
+	word := int(unsafe.Sizeof(uintptr(0)))
+	value := func(b []byte) uint64 {
+		if word == 4 {
+			return uint64(binary.LittleEndian.Uint32(b))
+		}
+		return binary.LittleEndian.Uint64(b)
+	}
+	auxv := make(map[uint64]uint64)
+	for i := 0; i+2*word <= len(data); i += 2 * word {
+		key := value(data[i:])
+		if key == 0 {
+			break
+		}
+		auxv[key] = value(data[i+word:])
+	}
+	return auxv, nil
+}
+
+// Randomized reports whether the layout of the process is randomized, it
+// isn't if its personality has ADDR_NO_RANDOMIZE or the kernel has it disabled
+// globally
+func (p *TracedProcess) Randomized() (bool, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/personality", p.Pid))
+	if err != nil {
+		return false, err
+	}
+	persona, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 32)
+	if err != nil {
+		return false, err
+	}
+	if persona&addrNoRandomize != 0 {
+		return false, nil
+	}
+	data, err = os.ReadFile("/proc/sys/kernel/randomize_va_space")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) != "0", nil
+}
+
+// TraceMetadata describes a traced process and its layout, needed to compare
+// addresses across runs
+type TraceMetadata struct {
+	Pid         int
+	Exe         string
+	BuildID     string
+	Args        []string
+	Randomized  bool
+	BaseAddress uintptr
+	Maps        []*procfs.ProcMap
+}
+
+// Metadata returns the metadata of the process the tracer was created for
+func (t *Tracer) Metadata() (*TraceMetadata, error) {
+	return t.session.Root().Metadata()
+}
+
+func (p *TracedProcess) Metadata() (*TraceMetadata, error) {
+	m := TraceMetadata{Pid: p.Pid, Exe: p.Exe}
+	proc, err := p.tracer.ProcFS.Proc(p.Pid)
+	if err != nil {
+		return nil, err
+	}
+	if m.Args, err = proc.CmdLine(); err != nil {
+		return nil, err
+	}
+	if m.BuildID, err = p.BuildID(); err != nil {
+		return nil, err
+	}
+	if m.Randomized, err = p.Randomized(); err != nil {
+		return nil, err
+	}
+	if m.BaseAddress, err = p.BaseAddress(); err != nil {
+		return nil, err
+	}
+	if m.Maps, err = p.MemMaps(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
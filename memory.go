@@ -0,0 +1,220 @@
+package riptracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Memory gives bulk access to the address space of a traced process. Reads and
+// writes use process_vm_readv/process_vm_writev, falling back to /proc/pid/mem
+// for pages those can't access (e.g. writing to read-only text) and finally to
+// word by word ptrace peek/poke.
+type Memory struct {
+	pid     int
+	memFile *os.File
+}
+
+// PartialAccessError is returned when only part of a read or write could be
+// transferred, typically because the range runs into an unmapped page
+type PartialAccessError struct {
+	Addr      uintptr
+	Requested int
+	Done      int
+	Err       error
+}
+
+func (e *PartialAccessError) Error() string {
+	return fmt.Sprintf("Partial access at 0x%x: %d of %d bytes: %v", e.Addr, e.Done, e.Requested, e.Err)
+}
+
+func (e *PartialAccessError) Unwrap() error {
+	return e.Err
+}
+
+func NewMemory(pid int) *Memory {
+	return &Memory{pid: pid}
+}
+
+// Memory returns a cached accessor for pid, which can be any traced thread
+func (t *Tracer) Memory(pid int) *Memory {
+	mem, ok := t.memory[pid]
+	if !ok {
+		mem = NewMemory(pid)
+		t.memory[pid] = mem
+	}
+	return mem
+}
+
+func (t *Tracer) releaseMemory(pid int) {
+	if mem, ok := t.memory[pid]; ok {
+		mem.Close()
+		delete(t.memory, pid)
+	}
+}
+
+// Close releases the cached /proc/pid/mem handle, if one was opened
+func (m *Memory) Close() error {
+	if m.memFile == nil {
+		return nil
+	}
+	err := m.memFile.Close()
+	m.memFile = nil
+	return err
+}
+
+func (m *Memory) procMem() (*os.File, error) {
+	if m.memFile == nil {
+		f, err := os.OpenFile(fmt.Sprintf("/proc/%d/mem", m.pid), os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		m.memFile = f
+	}
+	return m.memFile, nil
+}
+
+// ReadAt reads len(data) bytes starting at addr. If fewer bytes are available
+// the number read is returned along with a *PartialAccessError
+func (m *Memory) ReadAt(data []byte, addr uintptr) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	n, err := m.readVM(data, addr)
+	if n < len(data) {
+		// process_vm_readv stops at the first inaccessible page, /proc/pid/mem
+		// is allowed to read pages that aren't readable by the tracee itself
+		if f, ferr := m.procMem(); ferr == nil {
+			var cnt int
+			cnt, err = f.ReadAt(data[n:], int64(addr)+int64(n))
+			n += cnt
+		}
+	}
+	if n < len(data) {
+		var cnt int
+		cnt, err = unix.PtracePeekData(m.pid, addr+uintptr(n), data[n:])
+		if cnt > 0 {
+			n += cnt
+		}
+	}
+
+	if n < len(data) {
+		return n, &PartialAccessError{Addr: addr, Requested: len(data), Done: n, Err: err}
+	}
+	return n, nil
+}
+
+// WriteAt writes data starting at addr. Read-only mappings such as code are
+// written through /proc/pid/mem, which the kernel permits for a tracer
+func (m *Memory) WriteAt(data []byte, addr uintptr) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	n, err := m.writeVM(data, addr)
+	if n < len(data) {
+		if f, ferr := m.procMem(); ferr == nil {
+			var cnt int
+			cnt, err = f.WriteAt(data[n:], int64(addr)+int64(n))
+			n += cnt
+		}
+	}
+	if n < len(data) {
+		var cnt int
+		cnt, err = unix.PtracePokeData(m.pid, addr+uintptr(n), data[n:])
+		if cnt > 0 {
+			n += cnt
+		}
+	}
+
+	if n < len(data) {
+		return n, &PartialAccessError{Addr: addr, Requested: len(data), Done: n, Err: err}
+	}
+	return n, nil
+}
+
+func (m *Memory) readVM(data []byte, addr uintptr) (int, error) {
+	local := []unix.Iovec{{Base: &data[0]}}
+	local[0].SetLen(len(data))
+	remote := []unix.RemoteIovec{{Base: addr, Len: len(data)}}
+
+	n, err := unix.ProcessVMReadv(m.pid, local, remote, 0)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (m *Memory) writeVM(data []byte, addr uintptr) (int, error) {
+	local := []unix.Iovec{{Base: &data[0]}}
+	local[0].SetLen(len(data))
+	remote := []unix.RemoteIovec{{Base: addr, Len: len(data)}}
+
+	n, err := unix.ProcessVMWritev(m.pid, local, remote, 0)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// ReadCString reads a NUL terminated string of at most maxLen bytes. The
+// returned bool is true when no terminator was found within maxLen
+func (m *Memory) ReadCString(addr uintptr, maxLen int) (string, bool, error) {
+	data := make([]byte, maxLen+1)
+	n, err := m.ReadAt(data, addr)
+	if n == 0 {
+		return "", false, err
+	}
+	data = data[:n]
+	if idx := bytes.IndexByte(data, 0); idx >= 0 {
+		return string(data[:idx]), false, nil
+	}
+	if len(data) > maxLen {
+		return string(data[:maxLen]), true, nil
+	}
+	return string(data), true, err
+}
+
+func (m *Memory) ReadUint32(addr uintptr) (uint32, error) {
+	data := make([]byte, 4)
+	if _, err := m.ReadAt(data, addr); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+func (m *Memory) ReadUint64(addr uintptr) (uint64, error) {
+	data := make([]byte, 8)
+	if _, err := m.ReadAt(data, addr); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+// ReadPointer reads a pointer sized value for the architecture of the tracer
+func (m *Memory) ReadPointer(addr uintptr) (uintptr, error) {
+	if ptrSize == 4 {
+		v, err := m.ReadUint32(addr)
+		return uintptr(v), err
+	}
+	v, err := m.ReadUint64(addr)
+	return uintptr(v), err
+}
+
+func (m *Memory) WriteUint32(addr uintptr, value uint32) error {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, value)
+	_, err := m.WriteAt(data, addr)
+	return err
+}
+
+func (m *Memory) WriteUint64(addr uintptr, value uint64) error {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, value)
+	_, err := m.WriteAt(data, addr)
+	return err
+}
@@ -0,0 +1,168 @@
+package riptracer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMemoryReadWrite(t *testing.T) {
+	// process_vm_readv and /proc/self/mem work on ourselves without ptrace
+	mem := NewMemory(os.Getpid())
+	defer mem.Close()
+
+	src := []byte("riptracer\x00trailing")
+	addr := uintptr(unsafe.Pointer(&src[0]))
+
+	data := make([]byte, 9)
+	n, err := mem.ReadAt(data, addr)
+	if err != nil || n != 9 || string(data) != "riptracer" {
+		t.Fatalf("ReadAt returned %d, %v, %q", n, err, data)
+	}
+
+	str, truncated, err := mem.ReadCString(addr, 64)
+	if err != nil || truncated || str != "riptracer" {
+		t.Fatalf("ReadCString returned %q, %t, %v", str, truncated, err)
+	}
+	str, truncated, _ = mem.ReadCString(addr, 3)
+	if !truncated || str != "rip" {
+		t.Fatalf("ReadCString with limit returned %q, %t", str, truncated)
+	}
+
+	if err := mem.WriteUint32(addr, 0x44434241); err != nil {
+		t.Fatalf("WriteUint32 failed: %v", err)
+	}
+	if !bytes.HasPrefix(src, []byte("ABCDracer")) {
+		t.Fatalf("WriteUint32 didn't update memory: %q", src)
+	}
+
+	v, err := mem.ReadUint64(addr)
+	if err != nil || v != 0x65636172_44434241 {
+		t.Fatalf("ReadUint64 returned 0x%x, %v", v, err)
+	}
+
+	p, err := mem.ReadPointer(uintptr(unsafe.Pointer(&addr)))
+	if err != nil || p != addr {
+		t.Fatalf("ReadPointer returned 0x%x, %v", p, err)
+	}
+}
+
+func TestMemoryPartialRead(t *testing.T) {
+	pageSize := os.Getpagesize()
+	region, err := unix.Mmap(-1, 0, 2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		t.Fatalf("mmap failed: %v", err)
+	}
+	base := uintptr(unsafe.Pointer(&region[0]))
+	defer unix.Syscall(unix.SYS_MUNMAP, base, uintptr(pageSize), 0)
+
+	// Unmap the second page so that a read across the boundary is cut short
+	if _, _, errno := unix.Syscall(unix.SYS_MUNMAP, base+uintptr(pageSize), uintptr(pageSize), 0); errno != 0 {
+		t.Fatalf("munmap failed: %v", errno)
+	}
+
+	mem := NewMemory(os.Getpid())
+	defer mem.Close()
+
+	addr := base + uintptr(pageSize) - 4
+	data := make([]byte, 16)
+	n, err := mem.ReadAt(data, addr)
+
+	var partial *PartialAccessError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Expected PartialAccessError, got %v", err)
+	}
+	if n != 4 || partial.Done != 4 || partial.Requested != 16 {
+		t.Fatalf("Expected 4 of 16 bytes, got n=%d %+v", n, partial)
+	}
+}
+
+func TestMemoryPeekPokeFallback(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd, err := launchTraced(LaunchOptions{Args: []string{"/bin/true"}})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	pc, err := GetReg(cmd.Process.Pid, "pc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Code is read-only for process_vm_writev, a mem file that fails leaves
+	// only peek and poke
+	mem := NewMemory(cmd.Process.Pid)
+	if mem.memFile, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+
+	code := make([]byte, 3)
+	if _, err := mem.ReadAt(code, uintptr(pc)); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if _, err := mem.WriteAt([]byte{0xcc, 0x90, 0xcc}, uintptr(pc)); err != nil {
+		t.Fatalf("WriteAt failed: %v", err)
+	}
+	got := make([]byte, 3)
+	if _, err := mem.ReadAt(got, uintptr(pc)); err != nil || !bytes.Equal(got, []byte{0xcc, 0x90, 0xcc}) {
+		t.Fatalf("Read back % x, %v", got, err)
+	}
+}
+
+// startStoppedChild starts a traced child and returns it stopped after exec
+// along with the address of its stack mapping
+func startStoppedChild(b *testing.B) (*exec.Cmd, uintptr) {
+	cmd := exec.Command("/bin/sleep", "10")
+	cmd.SysProcAttr = &unix.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		b.Skipf("Unable to start traced child: %v", err)
+	}
+	var ws unix.WaitStatus
+	if _, err := unix.Wait4(cmd.Process.Pid, &ws, unix.WALL, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	var regs unix.PtraceRegs
+	check(unix.PtraceGetRegs(cmd.Process.Pid, &regs))
+	return cmd, uintptr(regs.PC()) & ^uintptr(0xfff)
+}
+
+func benchmarkRead(b *testing.B, size int, read func(pid int, addr uintptr, data []byte) error) {
+	cmd, addr := startStoppedChild(b)
+	defer cmd.Process.Kill()
+
+	data := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := read(cmd.Process.Pid, addr, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPtracePeekData4K(b *testing.B) {
+	benchmarkRead(b, 4096, func(pid int, addr uintptr, data []byte) error {
+		_, err := unix.PtracePeekData(pid, addr, data)
+		return err
+	})
+}
+
+func BenchmarkMemoryReadAt4K(b *testing.B) {
+	mem := map[int]*Memory{}
+	benchmarkRead(b, 4096, func(pid int, addr uintptr, data []byte) error {
+		if mem[pid] == nil {
+			mem[pid] = NewMemory(pid)
+		}
+		_, err := mem[pid].ReadAt(data, addr)
+		return err
+	})
+}
@@ -0,0 +1,229 @@
+package riptracer
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Ptrace options the loop relies on, fork children are always attached
+const tracerPtraceOptions = unix.PTRACE_O_TRACECLONE | unix.PTRACE_O_TRACEFORK | unix.PTRACE_O_TRACEVFORK |
+	unix.PTRACE_O_TRACEVFORKDONE | unix.PTRACE_O_TRACEEXEC
+
+// kcmp type comparing address spaces
+const kcmpVM = 1
+
+// processOf returns the process a traced thread belongs to
+func (t *Tracer) processOf(tid int) *TracedProcess {
+	if th, ok := t.threads[tid]; ok {
+		if proc, ok := t.session.processes[th.Pid]; ok {
+			return proc
+		}
+	}
+	return t.session.Root()
+}
+
+// tgidOf returns the process id of a thread, 0 if it's gone
+func (t *Tracer) tgidOf(tid int) int {
+	proc, err := t.ProcFS.Proc(tid)
+	if err != nil {
+		return 0
+	}
+	status, err := proc.NewStatus()
+	if err != nil {
+		return 0
+	}
+	return status.TGID
+}
+
+// sharesMemory reports whether two processes use the same address space
+func sharesMemory(pid1 int, pid2 int, fallback bool) bool {
+	r, _, errno := unix.Syscall6(unix.SYS_KCMP, uintptr(pid1), uintptr(pid2), kcmpVM, 0, 0, 0)
+	if errno != 0 {
+		return fallback
+	}
+	return r == 0
+}
+
+// handleNewProcess sets up a child process created by parent (fork, vfork or
+// clone without CLONE_THREAD) and starts it, or detaches from it. A forked
+// child gets a copy of its parent's breakpoint table, its memory holds the
+// same 0xCC bytes. An unfollowed child has them removed before it is detached.
+func (t *Tracer) handleNewProcess(parent int, child int, vfork bool) {
+	if err := t.waitNewChild(child); err != nil {
+		log.Printf("New process %d gone before it started: %v", child, err)
+		return
+	}
+
+	parentProc := t.processOf(parent)
+	parentSpace := parentProc.space
+	shared := sharesMemory(parent, child, vfork)
+	t.addThread(parent, 0).vforking = vfork
+
+	// A child sharing memory without vfork can't have its breakpoints removed
+	// without removing them from its parent, it is always followed
+	if !t.followForks && (vfork || !shared) {
+		t.removeBreakpoints(child, parentSpace)
+		if shared {
+			// The parent is suspended until the child execs or exits
+			parentSpace.vforkParked = true
+		} else {
+			t.unprotectPages(child, parentSpace)
+		}
+		if err := unix.PtraceDetach(child); err != nil && t.verbose {
+			log.Printf("Detach from new process %d failed: %v", child, err)
+		}
+		if t.verbose {
+			log.Printf("Not following new process %d of %d", child, parent)
+		}
+		return
+	}
+
+	space := parentSpace
+	if !shared {
+		space = newAddressSpace()
+		for addr, bp := range parentSpace.breakpoints {
+			copied := *bp
+			copied.Hits = 0
+			space.breakpoints[addr] = &copied
+		}
+		for page, p := range parentSpace.protectedPages {
+			copied := *p
+			space.protectedPages[page] = &copied
+		}
+		// The scratch page is inherited along with the rest of the memory
+		space.scratch = parentSpace.scratch
+	}
+	proc := t.session.add(t, child, parentProc.Pid, space)
+	// Same executable, same layout
+	proc.baseAddress = parentProc.baseAddress
+	t.addThread(child, parent).Pid = child
+	if t.verbose {
+		log.Printf("Following new process %d of %d (shared memory: %t)", child, parent, shared)
+	}
+	t.session.notify(ProcessEvent{Kind: ProcessStarted, Pid: child, Tid: parent, Parent: parentProc.Pid, Exe: proc.Exe})
+	if err := unix.PtraceCont(child, 0); err != nil {
+		log.Printf("Unable to start new process %d: %v", child, err)
+	}
+}
+
+// waitNewChild waits for the initial stop of an auto-attached child, unless
+// the loop already collected it
+func (t *Tracer) waitNewChild(child int) error {
+	if t.newChildren[child] {
+		delete(t.newChildren, child)
+		return nil
+	}
+	var ws unix.WaitStatus
+	for !ws.Stopped() {
+		if _, err := unix.Wait4(child, &ws, unix.WALL, nil); err != nil {
+			return err
+		}
+		if ws.Exited() || ws.Signaled() {
+			return unix.ESRCH
+		}
+	}
+	return nil
+}
+
+// holdNewChild keeps the first stop of a child process whose parent hasn't
+// reported the fork yet, it must not run before its breakpoints are known
+func (t *Tracer) holdNewChild(tid int, ws unix.WaitStatus) bool {
+	if _, ok := t.threads[tid]; ok || !ws.Stopped() {
+		return false
+	}
+	if proc, ok := t.session.processes[t.tgidOf(tid)]; ok && !proc.Exited {
+		return false
+	}
+	t.newChildren[tid] = true
+	return true
+}
+
+// vforkDone puts back breakpoints removed for an unfollowed vfork child
+func (t *Tracer) vforkDone(tid int) {
+	t.addThread(tid, 0).vforking = false
+	space := t.processOf(tid).space
+	if !space.vforkParked {
+		return
+	}
+	space.vforkParked = false
+	for addr := range space.breakpoints {
+		t.replaceCode(tid, addr, []byte{0xCC})
+	}
+}
+
+// execProcess starts over with an empty breakpoint table and base address
+// after tid execed and arms the breakpoint specs for the new executable. The
+// thread that called exec has taken over the pid of the process.
+func (t *Tracer) execProcess(tid int) {
+	proc, ok := t.session.processes[tid]
+	if !ok {
+		proc = t.session.add(t, tid, 0, nil)
+	}
+	proc.space = newAddressSpace()
+	proc.baseAddress = 0
+	proc.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", tid))
+	// Cached file handles refer to the old memory
+	t.releaseMemory(tid)
+	t.endTrace(tid)
+
+	// A thread other than the leader execing takes over its tid, the old tid
+	// is never reported as exited
+	if former, err := unix.PtraceGetEventMsg(tid); err == nil && int(former) != tid {
+		delete(t.threads, int(former))
+		t.releaseMemory(int(former))
+		t.endTrace(int(former))
+	}
+
+	if tid == t.session.root {
+		// Debug registers are cleared by exec too, page protections went
+		// with the old memory
+		t.hwbreakpoints = make(map[uintptr]*BreakPoint, 1)
+		t.pagewatchpoints = make(map[uintptr]*BreakPoint)
+	}
+
+	t.applyBreakpointSpecs(proc)
+	t.session.notify(ProcessEvent{Kind: ProcessExeced, Pid: tid, Tid: tid, Parent: proc.Parent, Exe: proc.Exe})
+}
+
+// removeThread forgets an exited thread and marks its process as exited once
+// it has no threads left
+func (t *Tracer) removeThread(tid int) {
+	th, ok := t.threads[tid]
+	delete(t.threads, tid)
+	t.releaseMemory(tid)
+	t.endTrace(tid)
+	if !ok {
+		return
+	}
+	for _, other := range t.threads {
+		if other.Pid == th.Pid {
+			return
+		}
+	}
+	if proc, ok := t.session.processes[th.Pid]; ok && !proc.Exited {
+		proc.Exited = true
+		t.session.notify(ProcessEvent{Kind: ProcessExited, Pid: proc.Pid, Tid: tid, Parent: proc.Parent, Exe: proc.Exe})
+	}
+}
+
+// removeBreakpoints restores the original code of all breakpoints of space
+// in the memory of tid
+func (t *Tracer) removeBreakpoints(tid int, space *addressSpace) {
+	for addr, bp := range space.breakpoints {
+		if bp.OriginalCode != nil {
+			t.replaceCode(tid, addr, *bp.OriginalCode)
+		}
+	}
+}
+
+// unprotectPages undoes the page protection of watchpoints of space in the
+// memory of tid, the pages stay registered
+func (t *Tracer) unprotectPages(tid int, space *addressSpace) {
+	pageSize := uintptr(os.Getpagesize())
+	for page, p := range space.protectedPages {
+		t.injectSyscall(tid, unix.SYS_MPROTECT, uint64(page), uint64(pageSize), uint64(p.prot))
+	}
+}
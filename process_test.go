@@ -0,0 +1,125 @@
+package riptracer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestSharesMemory(t *testing.T) {
+	cmd := exec.Command("/bin/sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	if !sharesMemory(os.Getpid(), os.Getpid(), false) {
+		t.Skip("kcmp not available")
+	}
+	if sharesMemory(os.Getpid(), cmd.Process.Pid, true) {
+		t.Errorf("Child process reported to share our address space")
+	}
+}
+
+//go:noinline
+func forkMark(n int) int {
+	return n + 1
+}
+
+// TestHelperForker isn't a real test, it is started by TestFollowForks as a
+// target that calls forkMark in a forked child and around a vfork
+func TestHelperForker(t *testing.T) {
+	if os.Getenv("RIPTRACER_FORKER") == "" {
+		t.Skip("Helper process")
+	}
+	forkMark(1)
+	pid, _, errno := syscall.RawSyscall(syscall.SYS_FORK, 0, 0, 0)
+	if errno != 0 {
+		os.Exit(2)
+	}
+	if pid == 0 {
+		// Only the forking thread exists, nothing may use the runtime
+		forkMark(2)
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 0, 0, 0)
+	}
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(int(pid), &ws, 0, nil); err != nil || !ws.Exited() || ws.ExitStatus() != 0 {
+		os.Exit(3)
+	}
+	// os/exec starts processes with vfork where it can
+	if err := exec.Command("/bin/true").Run(); err != nil {
+		os.Exit(4)
+	}
+	forkMark(3)
+	fmt.Print("ok")
+	os.Exit(0)
+}
+
+func TestFollowForks(t *testing.T) {
+	for _, follow := range []bool{true, false} {
+		t.Run(fmt.Sprintf("follow=%t", follow), func(t *testing.T) {
+			defer runtime.UnlockOSThread()
+			var stdout bytes.Buffer
+			tracer := launchTestTracer(t, LaunchOptions{
+				Args:   []string{os.Args[0], "-test.run=^TestHelperForker$"},
+				Env:    append(os.Environ(), "RIPTRACER_FORKER=1"),
+				Stdout: &stdout,
+			})
+			tracer.SetFollowForks(follow)
+			root := tracer.Session().Root()
+
+			hits := make(map[int]int)
+			copied := true
+			// Test binaries have no symbols, the helper is this binary
+			selfBias, err := newTestTracer(t, os.Getpid()).session.Root().LoadBias()
+			if err != nil {
+				t.Fatal(err)
+			}
+			bias, err := root.LoadBias()
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := reflect.ValueOf(forkMark).Pointer() - selfBias + bias
+			err = root.SetBreakpointAbsolute(addr, func(tid int, bp BreakPoint) {
+				proc := tracer.processOf(tid)
+				hits[proc.Pid]++
+				if proc != root && (proc.space == root.space || proc.space.breakpoints[bp.Address] == nil) {
+					copied = false
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tracer.Start()
+
+			// An unfollowed child with a breakpoint left dies of SIGTRAP
+			if stdout.String() != "ok" {
+				t.Fatalf("Helper failed, output %q", stdout.String())
+			}
+			if hits[root.Pid] != 2 {
+				t.Errorf("Parent hit the breakpoint %d times, expected 2", hits[root.Pid])
+			}
+			children := 0
+			for pid, n := range hits {
+				if pid != root.Pid {
+					children++
+					if n != 1 {
+						t.Errorf("Child %d hit the breakpoint %d times, expected 1", pid, n)
+					}
+				}
+			}
+			if follow && (children != 1 || !copied) {
+				t.Errorf("Breakpoint hit in %d children with copied table %t, expected 1", children, copied)
+			}
+			if !follow && children != 0 {
+				t.Errorf("Breakpoint hit in %d unfollowed children", children)
+			}
+		})
+	}
+}
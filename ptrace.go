@@ -0,0 +1,54 @@
+package riptracer
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Raw ptrace requests that golang.org/x/sys/unix doesn't wrap
+
+func ptrace(request int, pid int, addr uintptr, data uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_PTRACE, uintptr(request), uintptr(pid), addr, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptraceGetSiginfo returns the raw siginfo_t of the signal that stopped pid
+func ptraceGetSiginfo(pid int) ([]byte, error) {
+	info := make([]byte, 128)
+	err := ptrace(unix.PTRACE_GETSIGINFO, pid, 0, uintptr(unsafe.Pointer(&info[0])))
+	return info, err
+}
+
+// ptraceGetRegSet reads the register set identified by the ELF note type into
+// buf and returns the number of bytes the kernel filled in
+func ptraceGetRegSet(pid int, note int, buf []byte) (int, error) {
+	iov := unix.Iovec{Base: &buf[0]}
+	iov.SetLen(len(buf))
+	err := ptrace(unix.PTRACE_GETREGSET, pid, uintptr(note), uintptr(unsafe.Pointer(&iov)))
+	return int(iov.Len), err
+}
+
+func ptraceSetRegSet(pid int, note int, buf []byte) error {
+	iov := unix.Iovec{Base: &buf[0]}
+	iov.SetLen(len(buf))
+	return ptrace(unix.PTRACE_SETREGSET, pid, uintptr(note), uintptr(unsafe.Pointer(&iov)))
+}
+
+// ptraceSeize attaches to pid without stopping it, options are PTRACE_O_* flags
+func ptraceSeize(pid int, options int) error {
+	return ptrace(unix.PTRACE_SEIZE, pid, 0, uintptr(options))
+}
+
+// ptraceListen keeps a thread in group-stop while letting it report SIGCONT
+func ptraceListen(pid int) error {
+	return ptrace(unix.PTRACE_LISTEN, pid, 0, 0)
+}
+
+// ptraceDetachSignal detaches from pid delivering sig
+func ptraceDetachSignal(pid int, sig unix.Signal) error {
+	return ptrace(unix.PTRACE_DETACH, pid, 0, uintptr(sig))
+}
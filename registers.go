@@ -0,0 +1,215 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// subRegister is a bit field of a full register, e.g. "al" is bits 0-7 of rax
+type subRegister struct {
+	base  string
+	shift uint
+	width uint
+}
+
+// Bits of eflags that can be read and set by name
+var flagBits = map[string]uint{
+	"CF": 0,
+	"PF": 2,
+	"AF": 4,
+	"ZF": 6,
+	"SF": 7,
+	"TF": 8,
+	"IF": 9,
+	"DF": 10,
+	"OF": 11,
+}
+
+// resolveRegister maps a name to the full register it lives in and the bits it covers
+func resolveRegister(name string) (subRegister, error) {
+	if flag, ok := flagBits[name]; ok {
+		return subRegister{"eflags", flag, 1}, nil
+	}
+
+	name = strings.ToLower(name)
+	if alias, ok := registerAliases[name]; ok {
+		name = alias
+	}
+	if sub, ok := subRegisters[name]; ok {
+		return sub, nil
+	}
+
+	var regs unix.PtraceRegs
+	if _, ok := getRegisterField(&regs, name); ok {
+		return subRegister{name, 0, 64}, nil
+	}
+	return subRegister{}, fmt.Errorf("Unknown register %s", name)
+}
+
+func (s subRegister) mask() uint64 {
+	if s.width >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << s.width) - 1
+}
+
+// readRegister reads a register, subregister or flag by name from regs
+func readRegister(regs *unix.PtraceRegs, name string) (uint64, error) {
+	sub, err := resolveRegister(name)
+	if err != nil {
+		return 0, err
+	}
+	value, _ := getRegisterField(regs, sub.base)
+	return (value >> sub.shift) & sub.mask(), nil
+}
+
+// writeRegister updates a register, subregister or flag by name in regs. Bits
+// outside a subregister are preserved.
+func writeRegister(regs *unix.PtraceRegs, name string, value uint64) error {
+	sub, err := resolveRegister(name)
+	if err != nil {
+		return err
+	}
+	current, _ := getRegisterField(regs, sub.base)
+	current &^= sub.mask() << sub.shift
+	current |= (value & sub.mask()) << sub.shift
+	setRegisterField(regs, sub.base, current)
+	return nil
+}
+
+// GetReg reads a register of a stopped thread by name, e.g. "rax", "eax",
+// "al", a flag such as "ZF", an FPU control register such as "mxcsr" or the
+// segment bases "fs_base" and "gs_base"
+func GetReg(tid int, name string) (uint64, error) {
+	if _, _, ok := scalarFPRegister(strings.ToLower(name)); ok {
+		f, err := GetFPRegisters(tid)
+		if err != nil {
+			return 0, err
+		}
+		value, _ := f.Get(name)
+		return littleEndianValue(value), nil
+	}
+	if value, ok, err := segmentBase(tid, strings.ToLower(name)); ok {
+		return value, err
+	}
+
+	var regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return 0, err
+	}
+	return readRegister(&regs, name)
+}
+
+// SetReg writes a register of a stopped thread by name
+func SetReg(tid int, name string, value uint64) error {
+	if _, size, ok := scalarFPRegister(strings.ToLower(name)); ok {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, value)
+		return SetVectorReg(tid, name, buf[:size])
+	}
+
+	var regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return err
+	}
+	if err := writeRegister(&regs, name, value); err != nil {
+		return err
+	}
+	return unix.PtraceSetRegs(tid, &regs)
+}
+
+// Registers is a snapshot of the general purpose registers of a thread
+type Registers struct {
+	Tid    int
+	Values map[string]uint64
+}
+
+type RegisterChange struct {
+	Name string
+	Old  uint64
+	New  uint64
+}
+
+func GetRegisters(tid int) (*Registers, error) {
+	var regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return nil, err
+	}
+	return newRegisters(tid, &regs), nil
+}
+
+func newRegisters(tid int, regs *unix.PtraceRegs) *Registers {
+	r := Registers{Tid: tid, Values: make(map[string]uint64, len(registerNames))}
+	for _, name := range registerNames {
+		r.Values[name], _ = getRegisterField(regs, name)
+	}
+	return &r
+}
+
+// Get returns a register, subregister or flag from the snapshot
+func (r *Registers) Get(name string) (uint64, error) {
+	sub, err := resolveRegister(name)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := r.Values[sub.base]
+	if !ok {
+		return 0, fmt.Errorf("Register %s not in snapshot", sub.base)
+	}
+	return (value >> sub.shift) & sub.mask(), nil
+}
+
+// Diff returns the registers that changed between r and a later snapshot
+func (r *Registers) Diff(later *Registers) []RegisterChange {
+	changes := make([]RegisterChange, 0)
+	for _, name := range registerNames {
+		if r.Values[name] != later.Values[name] {
+			changes = append(changes, RegisterChange{Name: name, Old: r.Values[name], New: later.Values[name]})
+		}
+	}
+	return changes
+}
+
+func (r *Registers) String() string {
+	var sb strings.Builder
+	for _, name := range registerNames {
+		v := r.Values[name]
+		fmt.Fprintf(&sb, "%s%8s:%s 0x%016x (%d)%s\n", Blue, name, Green, v, v, Reset)
+	}
+	fmt.Fprintf(&sb, "%s   flags:%s %s%s\n", Blue, Green, r.flagString(), Reset)
+	return sb.String()
+}
+
+func (r *Registers) flagString() string {
+	flags := make([]string, 0)
+	for _, name := range []string{"CF", "PF", "AF", "ZF", "SF", "TF", "IF", "DF", "OF"} {
+		if v, _ := r.Get(name); v != 0 {
+			flags = append(flags, name)
+		}
+	}
+	return "[" + strings.Join(flags, " ") + "]"
+}
+
+// MarshalJSON encodes the snapshot as {"tid": ..., "registers": {"rax": ...}}
+func (r *Registers) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Tid       int               `json:"tid"`
+		Registers map[string]uint64 `json:"registers"`
+	}{r.Tid, r.Values})
+}
+
+func (r *Registers) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Tid       int               `json:"tid"`
+		Registers map[string]uint64 `json:"registers"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	r.Tid, r.Values = v.Tid, v.Registers
+	return nil
+}
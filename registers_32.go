@@ -0,0 +1,83 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+import "golang.org/x/sys/unix"
+
+// Order used when printing a Registers snapshot
+var registerNames = []string{
+	"eax", "ebx", "ecx", "edx", "esi", "edi", "ebp", "esp",
+	"eip", "eflags", "cs", "ss", "ds", "es", "fs", "gs", "orig_eax",
+}
+
+var registerAliases = map[string]string{
+	"pc": "eip",
+	"sp": "esp",
+	"fp": "ebp",
+}
+
+var subRegisters = map[string]subRegister{
+	"ax": {"eax", 0, 16}, "al": {"eax", 0, 8}, "ah": {"eax", 8, 8},
+	"bx": {"ebx", 0, 16}, "bl": {"ebx", 0, 8}, "bh": {"ebx", 8, 8},
+	"cx": {"ecx", 0, 16}, "cl": {"ecx", 0, 8}, "ch": {"ecx", 8, 8},
+	"dx": {"edx", 0, 16}, "dl": {"edx", 0, 8}, "dh": {"edx", 8, 8},
+	"si": {"esi", 0, 16}, "di": {"edi", 0, 16}, "bp": {"ebp", 0, 16},
+}
+
+func registerField(regs *unix.PtraceRegs, name string) *int32 {
+	switch name {
+	case "eax":
+		return &regs.Eax
+	case "ebx":
+		return &regs.Ebx
+	case "ecx":
+		return &regs.Ecx
+	case "edx":
+		return &regs.Edx
+	case "esi":
+		return &regs.Esi
+	case "edi":
+		return &regs.Edi
+	case "ebp":
+		return &regs.Ebp
+	case "esp":
+		return &regs.Esp
+	case "eip":
+		return &regs.Eip
+	case "eflags":
+		return &regs.Eflags
+	case "cs":
+		return &regs.Xcs
+	case "ss":
+		return &regs.Xss
+	case "ds":
+		return &regs.Xds
+	case "es":
+		return &regs.Xes
+	case "fs":
+		return &regs.Xfs
+	case "gs":
+		return &regs.Xgs
+	case "orig_eax":
+		return &regs.Orig_eax
+	}
+	return nil
+}
+
+func getRegisterField(regs *unix.PtraceRegs, name string) (uint64, bool) {
+	field := registerField(regs, name)
+	if field == nil {
+		return 0, false
+	}
+	return uint64(uint32(*field)), true
+}
+
+func setRegisterField(regs *unix.PtraceRegs, name string, value uint64) bool {
+	field := registerField(regs, name)
+	if field == nil {
+		return false
+	}
+	*field = int32(uint32(value))
+	return true
+}
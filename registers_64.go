@@ -0,0 +1,118 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+import "golang.org/x/sys/unix"
+
+// Order used when printing a Registers snapshot
+var registerNames = []string{
+	"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "rsp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
+	"rip", "eflags", "cs", "ss", "ds", "es", "fs", "gs",
+	"fs_base", "gs_base", "orig_rax",
+}
+
+var registerAliases = map[string]string{
+	"pc":     "rip",
+	"sp":     "rsp",
+	"fp":     "rbp",
+	"rflags": "eflags",
+}
+
+var subRegisters = map[string]subRegister{
+	"eax": {"rax", 0, 32}, "ax": {"rax", 0, 16}, "al": {"rax", 0, 8}, "ah": {"rax", 8, 8},
+	"ebx": {"rbx", 0, 32}, "bx": {"rbx", 0, 16}, "bl": {"rbx", 0, 8}, "bh": {"rbx", 8, 8},
+	"ecx": {"rcx", 0, 32}, "cx": {"rcx", 0, 16}, "cl": {"rcx", 0, 8}, "ch": {"rcx", 8, 8},
+	"edx": {"rdx", 0, 32}, "dx": {"rdx", 0, 16}, "dl": {"rdx", 0, 8}, "dh": {"rdx", 8, 8},
+	"esi": {"rsi", 0, 32}, "si": {"rsi", 0, 16}, "sil": {"rsi", 0, 8},
+	"edi": {"rdi", 0, 32}, "di": {"rdi", 0, 16}, "dil": {"rdi", 0, 8},
+	"ebp": {"rbp", 0, 32}, "bp": {"rbp", 0, 16}, "bpl": {"rbp", 0, 8},
+	"esp": {"rsp", 0, 32}, "spl": {"rsp", 0, 8},
+	"eip": {"rip", 0, 32},
+	"r8d": {"r8", 0, 32}, "r8w": {"r8", 0, 16}, "r8b": {"r8", 0, 8},
+	"r9d": {"r9", 0, 32}, "r9w": {"r9", 0, 16}, "r9b": {"r9", 0, 8},
+	"r10d": {"r10", 0, 32}, "r10w": {"r10", 0, 16}, "r10b": {"r10", 0, 8},
+	"r11d": {"r11", 0, 32}, "r11w": {"r11", 0, 16}, "r11b": {"r11", 0, 8},
+	"r12d": {"r12", 0, 32}, "r12w": {"r12", 0, 16}, "r12b": {"r12", 0, 8},
+	"r13d": {"r13", 0, 32}, "r13w": {"r13", 0, 16}, "r13b": {"r13", 0, 8},
+	"r14d": {"r14", 0, 32}, "r14w": {"r14", 0, 16}, "r14b": {"r14", 0, 8},
+	"r15d": {"r15", 0, 32}, "r15w": {"r15", 0, 16}, "r15b": {"r15", 0, 8},
+}
+
+func registerField(regs *unix.PtraceRegs, name string) *uint64 {
+	switch name {
+	case "rax":
+		return &regs.Rax
+	case "rbx":
+		return &regs.Rbx
+	case "rcx":
+		return &regs.Rcx
+	case "rdx":
+		return &regs.Rdx
+	case "rsi":
+		return &regs.Rsi
+	case "rdi":
+		return &regs.Rdi
+	case "rbp":
+		return &regs.Rbp
+	case "rsp":
+		return &regs.Rsp
+	case "r8":
+		return &regs.R8
+	case "r9":
+		return &regs.R9
+	case "r10":
+		return &regs.R10
+	case "r11":
+		return &regs.R11
+	case "r12":
+		return &regs.R12
+	case "r13":
+		return &regs.R13
+	case "r14":
+		return &regs.R14
+	case "r15":
+		return &regs.R15
+	case "rip":
+		return &regs.Rip
+	case "eflags":
+		return &regs.Eflags
+	case "cs":
+		return &regs.Cs
+	case "ss":
+		return &regs.Ss
+	case "ds":
+		return &regs.Ds
+	case "es":
+		return &regs.Es
+	case "fs":
+		return &regs.Fs
+	case "gs":
+		return &regs.Gs
+	case "fs_base":
+		return &regs.Fs_base
+	case "gs_base":
+		return &regs.Gs_base
+	case "orig_rax":
+		return &regs.Orig_rax
+	}
+	return nil
+}
+
+func getRegisterField(regs *unix.PtraceRegs, name string) (uint64, bool) {
+	field := registerField(regs, name)
+	if field == nil {
+		return 0, false
+	}
+	return *field, true
+}
+
+func setRegisterField(regs *unix.PtraceRegs, name string, value uint64) bool {
+	field := registerField(regs, name)
+	if field == nil {
+		return false
+	}
+	*field = value
+	return true
+}
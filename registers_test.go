@@ -0,0 +1,68 @@
+package riptracer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReadWriteRegister(t *testing.T) {
+	var regs unix.PtraceRegs
+
+	if err := writeRegister(&regs, "eax", 0x11223344); err != nil {
+		t.Fatalf("writeRegister failed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		expected uint64
+	}{
+		{"eax", 0x11223344},
+		{"ax", 0x3344},
+		{"al", 0x44},
+		{"ah", 0x33},
+		{"ZF", 0},
+	}
+	for _, test := range tests {
+		v, err := readRegister(&regs, test.name)
+		if err != nil || v != test.expected {
+			t.Errorf("Register %s: expected 0x%x got 0x%x, %v", test.name, test.expected, v, err)
+		}
+	}
+
+	// Writing a subregister keeps the other bits
+	check(writeRegister(&regs, "ah", 0xff))
+	if v, _ := readRegister(&regs, "eax"); v != 0x1122ff44 {
+		t.Errorf("Expected eax 0x1122ff44 after writing ah, got 0x%x", v)
+	}
+
+	check(writeRegister(&regs, "ZF", 1))
+	check(writeRegister(&regs, "CF", 1))
+	if v, _ := readRegister(&regs, "eflags"); v != 0x41 {
+		t.Errorf("Expected eflags 0x41 after setting ZF and CF, got 0x%x", v)
+	}
+
+	if _, err := readRegister(&regs, "xyz"); err == nil {
+		t.Errorf("Expected error for unknown register")
+	}
+}
+
+func TestRegistersDiffJSON(t *testing.T) {
+	var regs unix.PtraceRegs
+	before := newRegisters(1, &regs)
+	check(writeRegister(&regs, "sp", 0x1000))
+	after := newRegisters(1, &regs)
+
+	changes := before.Diff(after)
+	if len(changes) != 1 || changes[0].New != 0x1000 {
+		t.Fatalf("Unexpected diff %+v", changes)
+	}
+
+	data, err := json.Marshal(after)
+	check(err)
+	var decoded Registers
+	check(json.Unmarshal(data, &decoded))
+	if len(after.Diff(&decoded)) != 0 || decoded.Tid != 1 {
+		t.Fatalf("JSON round trip changed registers: %s", data)
+	}
+}
@@ -0,0 +1,238 @@
+package riptracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/prometheus/procfs"
+)
+
+// How much of a mapping is read at once while searching
+const searchChunkSize = 1 << 20
+
+// SearchPattern is a byte sequence where individual bytes may be wildcards
+type SearchPattern struct {
+	Bytes []byte
+	Mask  []bool // false marks a wildcard byte
+}
+
+type SearchOptions struct {
+	Perms      string // Required permissions, any of "rwx", e.g. "rw"
+	Module     string // Only search mappings whose path contains this string
+	Heap       bool   // Only search [heap]
+	Stack      bool   // Only search [stack]
+	MaxResults int    // Stop after this many matches, 0 for no limit
+}
+
+type SearchMatch struct {
+	Address uintptr
+	Map     *procfs.ProcMap
+}
+
+// PatternBytes parses hex bytes with "??" wildcards, e.g. "de ad ?? ef"
+func PatternBytes(pattern string) (SearchPattern, error) {
+	p := SearchPattern{}
+	fields := strings.Fields(pattern)
+	if len(fields) == 1 {
+		// Allow compact patterns such as "dead??ef"
+		fields = nil
+		for i := 0; i < len(pattern); i += 2 {
+			if i+2 > len(pattern) {
+				return p, fmt.Errorf("Odd number of hex digits in pattern: %s", pattern)
+			}
+			fields = append(fields, pattern[i:i+2])
+		}
+	}
+
+	for _, f := range fields {
+		if f == "??" || f == "?" {
+			p.Bytes = append(p.Bytes, 0)
+			p.Mask = append(p.Mask, false)
+			continue
+		}
+		b, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return SearchPattern{}, fmt.Errorf("Invalid byte in pattern: %s", f)
+		}
+		p.Bytes = append(p.Bytes, byte(b))
+		p.Mask = append(p.Mask, true)
+	}
+
+	if len(p.Bytes) == 0 {
+		return p, fmt.Errorf("Empty pattern")
+	}
+	return p, nil
+}
+
+func PatternString(s string) SearchPattern {
+	return exactPattern([]byte(s))
+}
+
+// PatternUTF16 matches s encoded as UTF-16LE, as used by wide strings
+func PatternUTF16(s string) SearchPattern {
+	units := utf16.Encode([]rune(s))
+	data := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(data[2*i:], u)
+	}
+	return exactPattern(data)
+}
+
+func PatternUint32(v uint32) SearchPattern {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return exactPattern(data)
+}
+
+func PatternUint64(v uint64) SearchPattern {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, v)
+	return exactPattern(data)
+}
+
+func exactPattern(data []byte) SearchPattern {
+	mask := make([]bool, len(data))
+	for i := range mask {
+		mask[i] = true
+	}
+	return SearchPattern{Bytes: data, Mask: mask}
+}
+
+func (p SearchPattern) hasWildcards() bool {
+	for _, m := range p.Mask {
+		if !m {
+			return true
+		}
+	}
+	return false
+}
+
+// findAll returns the offsets of every match of the pattern in data
+func (p SearchPattern) findAll(data []byte) []int {
+	offsets := make([]int, 0)
+	n := len(p.Bytes)
+	if n == 0 {
+		return offsets
+	}
+
+	if !p.hasWildcards() {
+		for pos := 0; ; {
+			idx := bytes.Index(data[pos:], p.Bytes)
+			if idx < 0 {
+				break
+			}
+			offsets = append(offsets, pos+idx)
+			pos += idx + 1
+		}
+		return offsets
+	}
+
+	for i := 0; i+n <= len(data); i++ {
+		matched := true
+		for j := 0; j < n; j++ {
+			if p.Mask[j] && data[i+j] != p.Bytes[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			offsets = append(offsets, i)
+		}
+	}
+	return offsets
+}
+
+func (opts SearchOptions) matches(m *procfs.ProcMap) bool {
+	if m.Perms != nil {
+		if strings.Contains(opts.Perms, "r") && !m.Perms.Read {
+			return false
+		}
+		if strings.Contains(opts.Perms, "w") && !m.Perms.Write {
+			return false
+		}
+		if strings.Contains(opts.Perms, "x") && !m.Perms.Execute {
+			return false
+		}
+	}
+	if opts.Module != "" && !strings.Contains(m.Pathname, opts.Module) {
+		return false
+	}
+	if opts.Heap || opts.Stack {
+		return (opts.Heap && m.Pathname == "[heap]") || (opts.Stack && m.Pathname == "[stack]")
+	}
+	// Reading these only returns errors
+	return m.Pathname != "[vvar]" && m.Pathname != "[vsyscall]"
+}
+
+// SearchMemory scans the mappings of the traced process for pattern and
+// returns each match along with the mapping it was found in. It has to run on
+// the tracer thread, like callbacks do.
+func (t *Tracer) SearchMemory(pattern SearchPattern, opts SearchOptions) ([]SearchMatch, error) {
+	results := make([]SearchMatch, 0)
+	if len(pattern.Bytes) == 0 {
+		return results, fmt.Errorf("Empty pattern")
+	}
+
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return results, err
+	}
+
+	mem := t.Memory(t.Process.Pid)
+
+	overlap := len(pattern.Bytes) - 1
+	buf := make([]byte, searchChunkSize+overlap)
+
+	for _, m := range procMaps {
+		if !opts.matches(m) {
+			continue
+		}
+
+		for addr := m.StartAddr; addr < m.EndAddr; addr += searchChunkSize {
+			size := min(int(m.EndAddr-addr), searchChunkSize+overlap)
+			n, _ := mem.ReadAt(buf[:size], addr)
+
+			for _, off := range pattern.findAll(buf[:n]) {
+				// Matches in the overlap are found again with the next chunk
+				if off >= searchChunkSize {
+					continue
+				}
+				results = append(results, SearchMatch{Address: addr + uintptr(off), Map: m})
+				if opts.MaxResults > 0 && len(results) >= opts.MaxResults {
+					return results, nil
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+// parseSearchInput turns interactive input into a pattern. Quoted input is a
+// string, otherwise hex bytes are tried before falling back to a string
+func parseSearchInput(input string) (SearchPattern, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return SearchPattern{}, fmt.Errorf("Empty pattern")
+	}
+	if s, err := strconv.Unquote(input); err == nil {
+		return PatternString(s), nil
+	}
+	if strings.HasPrefix(input, "0x") {
+		v, err := strconv.ParseUint(input[2:], 16, 64)
+		if err != nil {
+			return SearchPattern{}, err
+		}
+		if v > 0xffffffff {
+			return PatternUint64(v), nil
+		}
+		return PatternUint32(uint32(v)), nil
+	}
+	if p, err := PatternBytes(input); err == nil {
+		return p, nil
+	}
+	return PatternString(input), nil
+}
@@ -0,0 +1,66 @@
+package riptracer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPatternBytes(t *testing.T) {
+	p, err := PatternBytes("de ad ?? ef")
+	if err != nil {
+		t.Fatalf("PatternBytes failed: %s", err)
+	}
+	if !bytes.Equal(p.Bytes, []byte{0xde, 0xad, 0, 0xef}) || p.Mask[2] || !p.Mask[3] {
+		t.Fatalf("Unexpected pattern: %+v", p)
+	}
+
+	compact, err := PatternBytes("dead??ef")
+	if err != nil || !bytes.Equal(compact.Bytes, p.Bytes) {
+		t.Fatalf("Compact pattern mismatch: %+v, %v", compact, err)
+	}
+
+	for _, bad := range []string{"", "dea", "zz 00"} {
+		if _, err := PatternBytes(bad); err == nil {
+			t.Errorf("Expected error for pattern %q", bad)
+		}
+	}
+}
+
+func TestPatternFindAll(t *testing.T) {
+	data := []byte("xxkeyAkeyBk\x00e\x00y\x00")
+
+	tests := []struct {
+		pattern  SearchPattern
+		expected []int
+	}{
+		{PatternString("key"), []int{2, 6}},
+		{SearchPattern{Bytes: []byte("k?y"), Mask: []bool{true, false, true}}, []int{2, 6}},
+		{PatternUTF16("key"), []int{10}},
+		{PatternUint32(0x4179656b), []int{2}},
+		{PatternString("nope"), []int{}},
+	}
+	for _, test := range tests {
+		actual := test.pattern.findAll(data)
+		if !equal(actual, test.expected) {
+			t.Errorf("For pattern %q expected %v but got %v", test.pattern.Bytes, test.expected, actual)
+		}
+	}
+}
+
+func TestParseSearchInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []byte
+	}{
+		{`"hello world"`, []byte("hello world")},
+		{"0x41424344", []byte{0x44, 0x43, 0x42, 0x41}},
+		{"41 42", []byte{0x41, 0x42}},
+		{"flag{", []byte("flag{")},
+	}
+	for _, test := range tests {
+		p, err := parseSearchInput(test.input)
+		if err != nil || !bytes.Equal(p.Bytes, test.expected) {
+			t.Errorf("For input %q expected %v but got %v, %v", test.input, test.expected, p.Bytes, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package riptracer
+
+import (
+	"debug/elf"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/prometheus/procfs"
+)
+
+// Session is the tree of processes traced by a Tracer, the process it was
+// created for and the children it followed. Processes stay in the session
+// after they exit.
+type Session struct {
+	root      int
+	processes map[int]*TracedProcess
+	onEvent   func(ProcessEvent)
+}
+
+// TracedProcess is a process of the session with its own breakpoints, base
+// address and symbols. Relative addresses and symbols are resolved against
+// the executable it is currently running.
+type TracedProcess struct {
+	Pid    int
+	Parent int    // Process that created it, 0 for the first one
+	Exe    string // Executable, updated on exec
+	Exited bool
+
+	tracer      *Tracer
+	space       *addressSpace
+	baseAddress uintptr
+}
+
+// addressSpace holds the state tied to the memory of a process, shared by
+// processes created with vfork or CLONE_VM
+type addressSpace struct {
+	breakpoints    map[uintptr]*BreakPoint
+	protectedPages map[uintptr]*protectedPage // Pages made read-only for page watchpoints
+	scratch        uintptr                    // Page for displaced stepping
+	syscall        uintptr                    // System call instruction injected system calls run
+	vforkParked    bool                       // Breakpoints removed while an unfollowed vfork child runs
+}
+
+type ProcessEventKind int
+
+const (
+	ProcessStarted ProcessEventKind = iota // Forked, vforked or cloned by Parent
+	ProcessExeced
+	ProcessExited
+)
+
+func (k ProcessEventKind) String() string {
+	switch k {
+	case ProcessStarted:
+		return "started"
+	case ProcessExeced:
+		return "execed"
+	case ProcessExited:
+		return "exited"
+	}
+	return fmt.Sprintf("ProcessEventKind(%d)", int(k))
+}
+
+// ProcessEvent reports a change to the session. Tid is the thread that caused
+// it, e.g. the one that called fork or exec.
+type ProcessEvent struct {
+	Kind   ProcessEventKind
+	Pid    int
+	Tid    int
+	Parent int
+	Exe    string
+}
+
+func newAddressSpace() *addressSpace {
+	return &addressSpace{breakpoints: make(map[uintptr]*BreakPoint), protectedPages: make(map[uintptr]*protectedPage)}
+}
+
+func newSession(t *Tracer, pid int) *Session {
+	s := &Session{root: pid, processes: make(map[int]*TracedProcess)}
+	s.add(t, pid, 0, newAddressSpace())
+	return s
+}
+
+func (s *Session) add(t *Tracer, pid int, parent int, space *addressSpace) *TracedProcess {
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	p := &TracedProcess{Pid: pid, Parent: parent, Exe: exe, tracer: t, space: space}
+	s.processes[pid] = p
+	return p
+}
+
+func (s *Session) notify(e ProcessEvent) {
+	if s.onEvent != nil {
+		s.onEvent(e)
+	}
+}
+
+// Session returns the processes traced so far
+func (t *Tracer) Session() *Session {
+	return t.session
+}
+
+// SetEventCallback registers cb to be called when a process is started,
+// execs or exits. It runs on the tracer thread like breakpoint callbacks.
+func (s *Session) SetEventCallback(cb func(ProcessEvent)) {
+	s.onEvent = cb
+}
+
+// Root returns the process the tracer was created for
+func (s *Session) Root() *TracedProcess {
+	return s.processes[s.root]
+}
+
+func (s *Session) Process(pid int) (*TracedProcess, error) {
+	p, ok := s.processes[pid]
+	if !ok {
+		return nil, fmt.Errorf("Process %d isn't part of the session", pid)
+	}
+	return p, nil
+}
+
+// Processes returns all processes of the session ordered by pid
+func (s *Session) Processes() []*TracedProcess {
+	procs := make([]*TracedProcess, 0, len(s.processes))
+	for _, p := range s.processes {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].Pid < procs[j].Pid })
+	return procs
+}
+
+// Children returns the processes created by pid
+func (s *Session) Children(pid int) []*TracedProcess {
+	children := make([]*TracedProcess, 0)
+	for _, p := range s.Processes() {
+		if p.Parent == pid {
+			children = append(children, p)
+		}
+	}
+	return children
+}
+
+// MemMaps returns the current mappings of the process
+func (p *TracedProcess) MemMaps() ([]*procfs.ProcMap, error) {
+	proc, err := p.tracer.ProcFS.Proc(p.Pid)
+	if err != nil {
+		return nil, err
+	}
+	return proc.ProcMaps()
+}
+
+// BaseAddress returns where the first segment of the executable is mapped,
+// offsets passed to SetBreakpointRelative are relative to it
+func (p *TracedProcess) BaseAddress() (uintptr, error) {
+	if p.baseAddress > 0 {
+		return p.baseAddress, nil
+	}
+	bias, err := p.loadBias()
+	if err != nil {
+		return 0, err
+	}
+	resolver, err := p.Resolver()
+	if err != nil {
+		return 0, err
+	}
+	p.baseAddress = bias + uintptr(resolver.LoadAddr)
+	if p.tracer.verbose {
+		log.Printf("Base address of %d: 0x%x (bias 0x%x)", p.Pid, p.baseAddress, bias)
+	}
+	return p.baseAddress, nil
+}
+
+// LoadBias returns the difference between the addresses of the executable in
+// memory and its link time virtual addresses, 0 unless it is position
+// independent
+func (p *TracedProcess) LoadBias() (uintptr, error) {
+	base, err := p.BaseAddress()
+	if err != nil {
+		return 0, err
+	}
+	resolver, err := p.Resolver()
+	if err != nil {
+		return 0, err
+	}
+	return base - uintptr(resolver.LoadAddr), nil
+}
+
+// loadBias finds the bias from where the kernel put the program headers or
+// the entry point
+func (p *TracedProcess) loadBias() (uintptr, error) {
+	resolver, err := p.Resolver()
+	if err != nil {
+		return 0, err
+	}
+	if resolver.Type == elf.ET_EXEC {
+		return 0, nil
+	}
+	auxv, err := readAuxv(p.Pid)
+	if err != nil {
+		return 0, err
+	}
+	if phdr := auxv[auxvPhdr]; phdr != 0 && resolver.Phdr != 0 {
+		return uintptr(phdr - resolver.Phdr), nil
+	}
+	if entry := auxv[auxvEntry]; entry != 0 {
+		return uintptr(entry - resolver.Entry), nil
+	}
+	return 0, fmt.Errorf("Unable to find the load bias of process %d", p.Pid)
+}
+
+// FileOffsetToAddress returns where the byte at an offset of the executable
+// file is in memory
+func (p *TracedProcess) FileOffsetToAddress(offset uint64) (uintptr, error) {
+	if err := p.tracer.checkBuildID(p.Exe, true); err != nil {
+		return 0, err
+	}
+	resolver, err := p.Resolver()
+	if err != nil {
+		return 0, err
+	}
+	vaddr, err := resolver.FileOffsetToVaddr(offset)
+	if err != nil {
+		return 0, err
+	}
+	bias, err := p.LoadBias()
+	if err != nil {
+		return 0, err
+	}
+	return bias + uintptr(vaddr), nil
+}
+
+// Resolver returns the symbols of the executable the process is running
+func (p *TracedProcess) Resolver() (*SymbolResolver, error) {
+	return p.tracer.resolverForPath(p.Exe)
+}
+
+func (p *TracedProcess) SetBreakpointAbsolute(addr uintptr, cb CallBackFunction) error {
+	return p.setBreakpoint(addr, cb)
+}
+
+// SetBreakpointRelative sets a breakpoint at an offset from the base address
+// of the process
+func (p *TracedProcess) SetBreakpointRelative(offset uintptr, cb CallBackFunction) error {
+	addr, err := p.RelativeAddress(offset)
+	if err != nil {
+		return err
+	}
+	return p.setBreakpoint(addr, cb)
+}
+
+// RelativeAddress adds the base address to an offset, refused if the
+// executable doesn't have the pinned build-id
+func (p *TracedProcess) RelativeAddress(offset uintptr) (uintptr, error) {
+	if err := p.tracer.checkBuildID(p.Exe, true); err != nil {
+		return 0, err
+	}
+	base, err := p.BaseAddress()
+	if err != nil {
+		return 0, err
+	}
+	return base + offset, nil
+}
+
+// SetBreakpointSymbol sets a breakpoint on a function of the executable
+func (p *TracedProcess) SetBreakpointSymbol(name string, cb CallBackFunction) error {
+	addr, err := p.SymbolAddress(name)
+	if err != nil {
+		return err
+	}
+	return p.setBreakpoint(addr, cb)
+}
+
+// SymbolAddress returns where a symbol of the executable is in memory
+func (p *TracedProcess) SymbolAddress(name string) (uintptr, error) {
+	resolver, err := p.Resolver()
+	if err != nil {
+		return 0, err
+	}
+	sym, err := resolver.GetSymbolByName(name)
+	if err != nil {
+		return 0, err
+	}
+	bias, err := p.LoadBias()
+	if err != nil {
+		return 0, err
+	}
+	return bias + uintptr(sym.Value), nil
+}
+
+func (p *TracedProcess) setBreakpoint(addr uintptr, cb CallBackFunction) error {
+	if p.Exited {
+		return fmt.Errorf("Process %d has exited", p.Pid)
+	}
+	if breakpoint, ok := p.space.breakpoints[addr]; ok {
+		log.Printf("Breakpoint at 0x%x already set, adding cb...", addr)
+		breakpoint.Callbacks = append(breakpoint.Callbacks, cb)
+		return nil
+	}
+
+	if err := p.validateBreakpoint(addr); err != nil {
+		return err
+	}
+	log.Printf("Setting Breakpoint at 0x%x", addr)
+	org := make([]byte, 1)
+	mem := p.tracer.Memory(p.Pid)
+	if _, err := mem.ReadAt(org, addr); err != nil {
+		return err
+	}
+	if _, err := mem.WriteAt([]byte{0xCC}, addr); err != nil {
+		return err
+	}
+	p.space.breakpoints[addr] = &BreakPoint{Address: addr, OriginalCode: &org, Callbacks: []CallBackFunction{cb}, Prototype: p.tracer.prototypes[addr]}
+	return nil
+}
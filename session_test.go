@@ -0,0 +1,96 @@
+package riptracer
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSessionTree(t *testing.T) {
+	s := &Session{root: 10, processes: map[int]*TracedProcess{
+		10: {Pid: 10},
+		12: {Pid: 12, Parent: 10},
+		11: {Pid: 11, Parent: 10},
+		13: {Pid: 13, Parent: 12},
+	}}
+
+	procs := s.Processes()
+	for i, pid := range []int{10, 11, 12, 13} {
+		if procs[i].Pid != pid {
+			t.Fatalf("Processes not ordered by pid: %d at %d", procs[i].Pid, i)
+		}
+	}
+	children := s.Children(10)
+	if len(children) != 2 || children[0].Pid != 11 || children[1].Pid != 12 {
+		t.Errorf("Unexpected children of 10: %v", children)
+	}
+	if s.Root().Pid != 10 {
+		t.Errorf("Unexpected root %d", s.Root().Pid)
+	}
+	if _, err := s.Process(14); err == nil {
+		t.Errorf("Expected error for unknown process")
+	}
+
+	var events []ProcessEvent
+	s.SetEventCallback(func(e ProcessEvent) { events = append(events, e) })
+	s.notify(ProcessEvent{Kind: ProcessExited, Pid: 13})
+	if len(events) != 1 || events[0].Kind.String() != "exited" {
+		t.Errorf("Unexpected events %v", events)
+	}
+}
+
+func TestBaseAddressFromAuxv(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	exe, err := filepath.EvalSymlinks("/bin/true")
+	if err != nil {
+		t.Skip(err)
+	}
+	cmd, err := launchTraced(LaunchOptions{Args: []string{exe}})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	p := newTestTracer(t, cmd.Process.Pid).session.Root()
+
+	// The lowest mapping of the executable starts at the base address
+	base, err := p.BaseAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps, err := p.MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range maps {
+		if m.Pathname == exe {
+			if m.StartAddr != base {
+				t.Errorf("Base address 0x%x, executable mapped at 0x%x", base, m.StartAddr)
+			}
+			break
+		}
+	}
+
+	// The file offset of the entry point is loaded at AT_ENTRY
+	resolver, err := p.Resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auxv, err := readAuxv(p.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prog := range resolver.Loads {
+		if resolver.Entry < prog.Vaddr || resolver.Entry >= prog.Vaddr+prog.Filesz {
+			continue
+		}
+		addr, err := p.FileOffsetToAddress(resolver.Entry - prog.Vaddr + prog.Off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(addr) != auxv[auxvEntry] {
+			t.Errorf("Entry point at 0x%x, expected 0x%x", addr, auxv[auxvEntry])
+		}
+	}
+}
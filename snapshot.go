@@ -0,0 +1,175 @@
+package riptracer
+
+import (
+	"fmt"
+
+	"github.com/prometheus/procfs"
+)
+
+// Changed ranges separated by fewer unchanged bytes than this are reported as one
+const diffMergeGap = 8
+
+type MemoryRange struct {
+	Start uintptr
+	Size  int
+}
+
+type SnapshotRegion struct {
+	Start uintptr
+	Data  []byte
+	Map   *procfs.ProcMap
+}
+
+// Snapshot is a copy of selected memory of the traced process at one point in time
+type Snapshot struct {
+	Pid     int
+	Regions []SnapshotRegion
+}
+
+// MemoryChange is a range of bytes that differs between two snapshots
+type MemoryChange struct {
+	Address uintptr
+	Old     []byte
+	New     []byte
+	Map     *procfs.ProcMap
+	Symbol  string
+}
+
+// Snapshot copies the given ranges of the traced process memory
+func (t *Tracer) Snapshot(ranges []MemoryRange) (*Snapshot, error) {
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return nil, err
+	}
+
+	mem := t.Memory(t.Process.Pid)
+	snap := Snapshot{Pid: t.Process.Pid}
+
+	for _, r := range ranges {
+		data := make([]byte, r.Size)
+		n, err := mem.ReadAt(data, r.Start)
+		if n == 0 && err != nil {
+			return nil, err
+		}
+		snap.Regions = append(snap.Regions, SnapshotRegion{Start: r.Start, Data: data[:n], Map: findMapping(procMaps, r.Start)})
+	}
+	return &snap, nil
+}
+
+// SnapshotWritable copies every writable mapping of the traced process
+func (t *Tracer) SnapshotWritable() (*Snapshot, error) {
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return nil, err
+	}
+
+	mem := t.Memory(t.Process.Pid)
+	snap := Snapshot{Pid: t.Process.Pid}
+	opts := SearchOptions{Perms: "w"}
+
+	for _, m := range procMaps {
+		if !opts.matches(m) {
+			continue
+		}
+		data := make([]byte, m.EndAddr-m.StartAddr)
+		n, _ := mem.ReadAt(data, m.StartAddr)
+		snap.Regions = append(snap.Regions, SnapshotRegion{Start: m.StartAddr, Data: data[:n], Map: m})
+	}
+	return &snap, nil
+}
+
+// Diff compares the snapshot against a later one and returns the changed
+// ranges, in address order. Regions are matched by start address.
+func (s *Snapshot) Diff(later *Snapshot) []MemoryChange {
+	changes := make([]MemoryChange, 0)
+
+	for _, oldRegion := range s.Regions {
+		for _, newRegion := range later.Regions {
+			if oldRegion.Start != newRegion.Start {
+				continue
+			}
+			for _, r := range diffRanges(oldRegion.Data, newRegion.Data) {
+				oldEnd := min(r[1], len(oldRegion.Data))
+				changes = append(changes, MemoryChange{
+					Address: oldRegion.Start + uintptr(r[0]),
+					Old:     oldRegion.Data[min(r[0], oldEnd):oldEnd],
+					New:     newRegion.Data[r[0]:r[1]],
+					Map:     newRegion.Map,
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// DiffSnapshots is Diff with each change labelled with the symbol it falls in
+func (t *Tracer) DiffSnapshots(before *Snapshot, after *Snapshot) []MemoryChange {
+	changes := before.Diff(after)
+
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return changes
+	}
+	for i := range changes {
+		changes[i].Symbol = t.symbolize(changes[i].Address, procMaps)
+	}
+	return changes
+}
+
+// PrintDiff prints the changes grouped by mapping and symbol, with a hexdump
+// highlighting the changed bytes
+func PrintDiff(changes []MemoryChange) {
+	lastMap := ""
+	for _, c := range changes {
+		mapName := "[anon]"
+		if c.Map != nil {
+			mapName = fmt.Sprintf("%s 0x%x-0x%x", c.Map.Pathname, c.Map.StartAddr, c.Map.EndAddr)
+		}
+		if mapName != lastMap {
+			fmt.Println(Blue, "----------", mapName, "----------", Reset)
+			lastMap = mapName
+		}
+
+		if c.Symbol != "" {
+			fmt.Printf("%s%s%s (%d bytes)\n", Yellow, c.Symbol, Reset, len(c.New))
+		} else {
+			fmt.Printf("%s0x%x%s (%d bytes)\n", Yellow, c.Address, Reset, len(c.New))
+		}
+		DumpDiff(c.Address, c.Old, c.New)
+	}
+}
+
+// diffRanges returns [start, end) pairs of bytes that differ between a and b.
+// Bytes past the end of a count as changed.
+func diffRanges(a []byte, b []byte) [][2]int {
+	ranges := make([][2]int, 0)
+	start := -1
+	lastChanged := -1
+
+	for i := range b {
+		if i < len(a) && a[i] == b[i] {
+			continue
+		}
+		if start >= 0 && i-lastChanged > diffMergeGap {
+			ranges = append(ranges, [2]int{start, lastChanged + 1})
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+		lastChanged = i
+	}
+	if start >= 0 {
+		ranges = append(ranges, [2]int{start, lastChanged + 1})
+	}
+	return ranges
+}
+
+func findMapping(procMaps []*procfs.ProcMap, addr uintptr) *procfs.ProcMap {
+	for _, m := range procMaps {
+		if addr >= m.StartAddr && addr < m.EndAddr {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package riptracer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRanges(t *testing.T) {
+	tests := []struct {
+		a        []byte
+		b        []byte
+		expected [][2]int
+	}{
+		{[]byte("aaaaaaaa"), []byte("aaaaaaaa"), [][2]int{}},
+		{[]byte("aaaaaaaa"), []byte("abbaaaaa"), [][2]int{{1, 3}}},
+		// Changes close together are merged
+		{[]byte("aaaaaaaa"), []byte("baaaaaab"), [][2]int{{0, 8}}},
+		{[]byte("aaaaaaaaaaaaaaaaaaaa"), []byte("baaaaaaaaaaaaaaaaaab"), [][2]int{{0, 1}, {19, 20}}},
+		// Growth counts as changed
+		{[]byte("aa"), []byte("aabb"), [][2]int{{2, 4}}},
+	}
+	for _, test := range tests {
+		actual := diffRanges(test.a, test.b)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("For %q -> %q expected %v but got %v", test.a, test.b, test.expected, actual)
+		}
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	before := &Snapshot{Regions: []SnapshotRegion{{Start: 0x1000, Data: []byte("hello world")}}}
+	after := &Snapshot{Regions: []SnapshotRegion{{Start: 0x1000, Data: []byte("hello WORLD")}}}
+
+	changes := before.Diff(after)
+	if len(changes) != 1 {
+		t.Fatalf("Expected one change, got %+v", changes)
+	}
+	c := changes[0]
+	if c.Address != 0x1006 || string(c.Old) != "world" || string(c.New) != "WORLD" {
+		t.Fatalf("Unexpected change %+v", c)
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"debug/elf"
 	"encoding/binary"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 	"unsafe"
 
 	"github.com/ianlancetaylor/demangle"
+	"github.com/prometheus/procfs"
 )
 
 type ELF64_Rela_Info struct {
@@ -43,7 +47,10 @@ func parsePlt(f *elf.File) []elf.Symbol {
 	plt := make([]elf.Symbol, 0)
 
 	dynSyms, err := f.DynamicSymbols()
-	check(err)
+	if err != nil {
+		// e.g. IRELATIVE relocations in static binaries
+		return plt
+	}
 
 	rpSec := f.Section(".rela.plt")
 	cnt := 0
@@ -56,6 +63,12 @@ func parsePlt(f *elf.File) []elf.Symbol {
 			break
 		}
 
+		// Keep a placeholder for relocations without symbol (IRELATIVE) so
+		// indices still match the PLT slots
+		if rela.R_info.Sym == 0 || int(rela.R_info.Sym) > len(dynSyms) {
+			plt = append(plt, elf.Symbol{})
+			continue
+		}
 		idx := rela.R_info.Sym - 1
 		sym := dynSyms[idx]
 		demangledName, err := demangle.ToString(sym.Name, demangle.Option(demangle.NoParams), demangle.Option(demangle.NoTemplateParams), demangle.Option(demangle.LLVMStyle))
@@ -68,9 +81,69 @@ func parsePlt(f *elf.File) []elf.Symbol {
 	return plt
 }
 
+// parseSymbols returns the function and object symbols sorted by address, one
+// for each address, and every name including aliases. The value of an IFUNC
+// symbol is its resolver, not the implementation it picks.
+func parseSymbols(f *elf.File) ([]elf.Symbol, map[string]elf.Symbol) {
+	syms := make([]elf.Symbol, 0)
+	names := make(map[string]elf.Symbol)
+
+	// Stripped binaries only have the dynamic symbols left
+	staticSyms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	seen := make(map[uint64]bool)
+
+	for _, sym := range append(staticSyms, dynSyms...) {
+		typ := elf.ST_TYPE(sym.Info)
+		if (typ != elf.STT_FUNC && typ != elf.STT_OBJECT && typ != elf.STT_GNU_IFUNC) || sym.Value == 0 {
+			continue
+		}
+
+		demangledName, err := demangle.ToString(sym.Name, demangle.Option(demangle.NoParams), demangle.Option(demangle.NoTemplateParams), demangle.Option(demangle.LLVMStyle))
+		if err == nil {
+			sym.Name = demangledName
+		}
+		if _, ok := names[sym.Name]; !ok {
+			names[sym.Name] = sym
+		}
+		if !seen[sym.Value] {
+			seen[sym.Value] = true
+			syms = append(syms, sym)
+		}
+	}
+
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Value < syms[j].Value })
+	return syms, names
+}
+
+func parseTLSSymbols(f *elf.File) []elf.Symbol {
+	syms := make([]elf.Symbol, 0)
+	staticSyms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+
+	for _, sym := range append(staticSyms, dynSyms...) {
+		if elf.ST_TYPE(sym.Info) == elf.STT_TLS && sym.Section != elf.SHN_UNDEF {
+			syms = append(syms, sym)
+		}
+	}
+	return syms
+}
+
 type SymbolResolver struct {
 	PLT        []elf.Symbol
+	Symbols    []elf.Symbol // Function and object symbols sorted by address, aliases left out
+	Type       elf.Type
+	LoadAddr   uint64 // Page aligned virtual address of the first PT_LOAD segment
+	Entry      uint64
+	Phdr       uint64 // Virtual address of the program headers (PT_PHDR), 0 if not loaded
+	Loads      []elf.ProgHeader
+	TLS        *elf.ProgHeader
+	TLSSymbols []elf.Symbol // Values are offsets into the TLS block
+	Dynamic    uint64       // Virtual address of the dynamic section, 0 if static
+	BuildID    string       // Hex encoded GNU build-id, empty if there is none
 	pltSection *elf.Section
+	plts       []elf.SectionHeader // .plt, .plt.got and .plt.sec
+	names      map[string]elf.Symbol
 }
 
 func NewSymbolResolver(filepath string) (*SymbolResolver, error) {
@@ -80,17 +153,97 @@ func NewSymbolResolver(filepath string) (*SymbolResolver, error) {
 	}
 	defer f.Close()
 
-	pltSect := f.Section(".plt")
-	if pltSect == nil {
-		return nil, fmt.Errorf("Couldn't find dynstr")
+	s := SymbolResolver{Type: f.Type, Entry: f.Entry, BuildID: parseBuildID(f)}
+	s.Symbols, s.names = parseSymbols(f)
+	s.TLSSymbols = parseTLSSymbols(f)
+	loadFound := false
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_LOAD:
+			if !loadFound {
+				s.LoadAddr = prog.Vaddr &^ (prog.Align - 1)
+				loadFound = true
+			}
+			s.Loads = append(s.Loads, prog.ProgHeader)
+		case elf.PT_PHDR:
+			s.Phdr = prog.Vaddr
+		case elf.PT_TLS:
+			tls := prog.ProgHeader
+			s.TLS = &tls
+		case elf.PT_DYNAMIC:
+			s.Dynamic = prog.Vaddr
+		}
+	}
+
+	for _, sect := range f.Sections {
+		if strings.HasPrefix(sect.Name, ".plt") {
+			s.plts = append(s.plts, sect.SectionHeader)
+		}
 	}
 
-	s := SymbolResolver{pltSection: pltSect}
-	s.PLT = parsePlt(f)
+	// Statically linked binaries don't have a PLT, symbol lookups still work
+	pltSect := f.Section(".plt")
+	if pltSect != nil && f.Section(".rela.plt") != nil {
+		s.pltSection = pltSect
+		s.PLT = parsePlt(f)
+	}
 	return &s, nil
 }
 
+// FileOffsetToVaddr returns the link time virtual address the byte at a file
+// offset is loaded to
+func (s *SymbolResolver) FileOffsetToVaddr(offset uint64) (uint64, error) {
+	for _, prog := range s.Loads {
+		if offset >= prog.Off && offset < prog.Off+prog.Filesz {
+			return prog.Vaddr + offset - prog.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("File offset 0x%x isn't in a loaded segment", offset)
+}
+
+// inPLT reports whether a link time virtual address is in a PLT section
+func (s *SymbolResolver) inPLT(offset uint64) bool {
+	for _, sect := range s.plts {
+		if offset >= sect.Addr && offset < sect.Addr+sect.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSymbolByOffset returns the symbol containing offset (a link time virtual
+// address) and the distance from the start of the symbol
+func (s *SymbolResolver) GetSymbolByOffset(offset uint64) (elf.Symbol, uint64, error) {
+	idx := sort.Search(len(s.Symbols), func(i int) bool { return s.Symbols[i].Value > offset }) - 1
+	if idx >= 0 {
+		sym := s.Symbols[idx]
+		if offset < sym.Value+sym.Size || (sym.Size == 0 && offset == sym.Value) {
+			return sym, offset - sym.Value, nil
+		}
+	}
+	return elf.Symbol{}, 0, fmt.Errorf("Couldn't find symbol at offset 0x%8.8x", offset)
+}
+
+func (s *SymbolResolver) GetSymbolByName(symName string) (elf.Symbol, error) {
+	if sym, ok := s.names[symName]; ok {
+		return sym, nil
+	}
+	return elf.Symbol{}, fmt.Errorf("Couldn't find symbol %s in file", symName)
+}
+
+func (s *SymbolResolver) GetTLSSymbolByName(symName string) (elf.Symbol, error) {
+	for i := range s.TLSSymbols {
+		if s.TLSSymbols[i].Name == symName {
+			return s.TLSSymbols[i], nil
+		}
+	}
+	return elf.Symbol{}, fmt.Errorf("Couldn't find TLS symbol %s in file", symName)
+}
+
 func (s *SymbolResolver) GetPLTOffsetBySymName(symName string) (uintptr, error) {
+	if s.pltSection == nil {
+		return 0, fmt.Errorf("No PLT in file")
+	}
 	for i := range s.PLT {
 		sym := s.PLT[i]
 		if sym.Name == symName {
@@ -103,6 +256,9 @@ func (s *SymbolResolver) GetPLTOffsetBySymName(symName string) (uintptr, error)
 }
 
 func (s *SymbolResolver) GetPLTSymNameByOffset(offset uint64) (string, error) {
+	if s.pltSection == nil {
+		return "", fmt.Errorf("No PLT in file")
+	}
 
 	/*
 		idx := (offset - (s.pltSection.Addr + s.pltSection.Entsize)) / s.pltSection.Entsize
@@ -122,3 +278,54 @@ func (s *SymbolResolver) GetPLTSymNameByOffset(offset uint64) (string, error) {
 
 	return "", fmt.Errorf("Couldn't find symbol at offset 0x%8.8x", offset)
 }
+
+// resolverForPath returns a cached SymbolResolver for a mapped file
+func (t *Tracer) resolverForPath(path string) (*SymbolResolver, error) {
+	if s, ok := t.resolvers[path]; ok {
+		if s == nil {
+			return nil, fmt.Errorf("No symbols for %s", path)
+		}
+		return s, nil
+	}
+	s, err := NewSymbolResolver(path)
+	t.resolvers[path] = s
+	return s, err
+}
+
+// symbolize describes addr as symbol+offset using the mappings in procMaps,
+// falling back to module+offset when no symbol covers the address
+func (t *Tracer) symbolize(addr uintptr, procMaps []*procfs.ProcMap) string {
+	owner := findMapping(procMaps, addr)
+	if owner == nil || !strings.HasPrefix(owner.Pathname, "/") {
+		return ""
+	}
+
+	start := fileStart(procMaps, owner)
+	name := filepath.Base(owner.Pathname)
+	s, err := t.resolverForPath(owner.Pathname)
+	if err != nil {
+		return fmt.Sprintf("%s+0x%x", name, addr-start)
+	}
+
+	offset := uint64(addr-start) + s.LoadAddr
+	sym, symOffset, err := s.GetSymbolByOffset(offset)
+	if err != nil {
+		return fmt.Sprintf("%s+0x%x", name, addr-start)
+	}
+	if symOffset == 0 {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+0x%x", sym.Name, symOffset)
+}
+
+// fileStart returns where the first PT_LOAD segment of the file mapped by
+// owner landed, the lowest mapping of the file
+func fileStart(procMaps []*procfs.ProcMap, owner *procfs.ProcMap) uintptr {
+	start := owner.StartAddr
+	for _, m := range procMaps {
+		if m.Pathname == owner.Pathname && m.StartAddr < start {
+			start = m.StartAddr
+		}
+	}
+	return start
+}
@@ -1,6 +1,9 @@
 package riptracer
 
-import "testing"
+import (
+	"os/exec"
+	"testing"
+)
 
 func TestParseELF64RelaEntry(t *testing.T) {
 	// Test data representing an ELF64_Rela struct
@@ -23,3 +26,39 @@ func TestParseELF64RelaEntry(t *testing.T) {
 			expected, result)
 	}
 }
+
+func TestGetSymbolByNameAliases(t *testing.T) {
+	prog := compileTestProgram(t, `
+int impl(void) { return 1; }
+int alias(void) __attribute__((alias("impl")));
+static int (*pick(void))(void) { return impl; }
+int ifn(void) __attribute__((ifunc("pick")));
+int main(void) { return alias() + ifn() - 2; }
+`)
+	if out, err := exec.Command(prog).CombinedOutput(); err != nil {
+		t.Skipf("Unable to run the target: %v %s", err, out)
+	}
+	s, err := NewSymbolResolver(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impl, err := s.GetSymbolByName("impl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alias, err := s.GetSymbolByName("alias")
+	if err != nil || alias.Value != impl.Value {
+		t.Errorf("alias at 0x%x, impl at 0x%x: %v", alias.Value, impl.Value, err)
+	}
+	// The address still resolves to a single name
+	if sym, _, err := s.GetSymbolByOffset(impl.Value); err != nil || (sym.Name != "impl" && sym.Name != "alias") {
+		t.Errorf("0x%x resolved to %q: %v", impl.Value, sym.Name, err)
+	}
+	if _, err := s.GetSymbolByName("ifn"); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.GetSymbolByName("missing"); err == nil {
+		t.Error("Found a symbol that doesn't exist")
+	}
+}
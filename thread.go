@@ -0,0 +1,201 @@
+package riptracer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Thread is a traced thread. Name and State are refreshed when the thread is
+// returned by Threads or GetThread.
+type Thread struct {
+	Tid     int
+	Pid     int // Process the thread belongs to
+	Name    string
+	Parent  int // Thread that created it, 0 if it existed before we attached
+	Created time.Time
+	State   string
+	Ignored bool            // Breakpoints and watchpoints don't trigger for it
+	Hits    map[uintptr]int // Hits per breakpoint/watchpoint address
+
+	vforking bool  // Suspended until its vfork child execs or exits
+	signals  []int // Held back while stepping, delivered by the next continue
+}
+
+// ThreadFilter restricts a breakpoint to threads with one of the given tids
+// or a name matching Names
+type ThreadFilter struct {
+	Tids  map[int]bool
+	Names *regexp.Regexp
+}
+
+func (f *ThreadFilter) Match(th *Thread) bool {
+	if f.Tids[th.Tid] {
+		return true
+	}
+	return f.Names != nil && f.Names.MatchString(th.Name)
+}
+
+func (t *Tracer) addThread(tid int, parent int) *Thread {
+	if th, ok := t.threads[tid]; ok {
+		return th
+	}
+	th := &Thread{Tid: tid, Pid: t.tgidOf(tid), Parent: parent, Created: time.Now(), Hits: make(map[uintptr]int)}
+	if th.Pid == 0 {
+		th.Pid = t.Process.Pid
+	}
+	t.refreshThread(th)
+	if parent == 0 {
+		if proc, err := t.ProcFS.Thread(tid, tid); err == nil {
+			if stat, err := proc.Stat(); err == nil {
+				if start, err := stat.StartTime(); err == nil {
+					th.Created = time.Unix(0, int64(start*float64(time.Second)))
+				}
+			}
+		}
+	}
+	t.threads[tid] = th
+	return th
+}
+
+// refreshThread rereads the name and state from /proc/tid/task/tid
+func (t *Tracer) refreshThread(th *Thread) {
+	proc, err := t.ProcFS.Thread(th.Tid, th.Tid)
+	if err != nil {
+		return
+	}
+	if name, err := proc.Comm(); err == nil {
+		th.Name = name
+	}
+	if stat, err := proc.Stat(); err == nil {
+		th.State = stat.State
+	}
+}
+
+// Threads returns a copy of all traced threads ordered by tid
+func (t *Tracer) Threads() []Thread {
+	threads := make([]Thread, 0, len(t.threads))
+	for _, th := range t.threads {
+		t.refreshThread(th)
+		threads = append(threads, th.copy())
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].Tid < threads[j].Tid })
+	return threads
+}
+
+func (t *Tracer) GetThread(tid int) (Thread, error) {
+	th, ok := t.threads[tid]
+	if !ok {
+		return Thread{}, fmt.Errorf("Thread %d isn't traced", tid)
+	}
+	t.refreshThread(th)
+	return th.copy(), nil
+}
+
+func (th *Thread) copy() Thread {
+	c := *th
+	c.Hits = make(map[uintptr]int, len(th.Hits))
+	for addr, hits := range th.Hits {
+		c.Hits[addr] = hits
+	}
+	return c
+}
+
+// IgnoreThread makes breakpoints and watchpoints skip tid, it keeps running
+// normally otherwise
+func (t *Tracer) IgnoreThread(tid int, ignore bool) error {
+	th, ok := t.threads[tid]
+	if !ok {
+		return fmt.Errorf("Thread %d isn't traced", tid)
+	}
+	th.Ignored = ignore
+	return nil
+}
+
+// SetBreakpointThreads restricts the breakpoint or watchpoint at addr to the
+// given threads, in every traced process with a breakpoint there
+func (t *Tracer) SetBreakpointThreads(addr uintptr, tids ...int) error {
+	bps, err := t.breakpointsAt(addr)
+	if err != nil {
+		return err
+	}
+	for _, bp := range bps {
+		if bp.Filter == nil {
+			bp.Filter = &ThreadFilter{}
+		}
+		bp.Filter.Tids = make(map[int]bool, len(tids))
+		for _, tid := range tids {
+			bp.Filter.Tids[tid] = true
+		}
+	}
+	return nil
+}
+
+// SetBreakpointThreadNames restricts the breakpoint or watchpoint at addr to
+// threads with a name matching the regular expression pattern, in every traced
+// process like SetBreakpointThreads
+func (t *Tracer) SetBreakpointThreadNames(addr uintptr, pattern string) error {
+	bps, err := t.breakpointsAt(addr)
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	for _, bp := range bps {
+		if bp.Filter == nil {
+			bp.Filter = &ThreadFilter{}
+		}
+		bp.Filter.Names = re
+	}
+	return nil
+}
+
+// breakpointsAt returns the breakpoints and watchpoints at addr, breakpoints
+// of every traced process
+func (t *Tracer) breakpointsAt(addr uintptr) ([]*BreakPoint, error) {
+	found := make([]*BreakPoint, 0)
+	seen := make(map[*addressSpace]bool)
+	for _, p := range t.session.processes {
+		if seen[p.space] {
+			continue
+		}
+		seen[p.space] = true
+		if bp, ok := p.space.breakpoints[addr]; ok {
+			found = append(found, bp)
+		}
+	}
+	for _, bps := range []map[uintptr]*BreakPoint{t.hwbreakpoints, t.pagewatchpoints} {
+		if bp, ok := bps[addr]; ok {
+			found = append(found, bp)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("No breakpoint at 0x%x", addr)
+	}
+	return found, nil
+}
+
+// hitBreakpoint counts a hit of bp by tid and reports whether its callbacks
+// should run
+func (t *Tracer) hitBreakpoint(tid int, bp *BreakPoint) bool {
+	th := t.addThread(tid, 0)
+	if th.Ignored {
+		return false
+	}
+	if bp.Filter != nil {
+		if bp.Filter.Names != nil {
+			// Threads can rename themselves at any time
+			t.refreshThread(th)
+		}
+		if !bp.Filter.Match(th) {
+			return false
+		}
+	}
+	bp.Hits += 1
+	bp.Pid, bp.Tid = th.Pid, tid
+	th.Hits[bp.Address] += 1
+	return true
+}
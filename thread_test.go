@@ -0,0 +1,66 @@
+package riptracer
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestThreadFilter(t *testing.T) {
+	filter := ThreadFilter{Tids: map[int]bool{10: true}, Names: regexp.MustCompile(`^worker-\d+$`)}
+	cases := []struct {
+		th    Thread
+		match bool
+	}{
+		{Thread{Tid: 10, Name: "main"}, true},
+		{Thread{Tid: 11, Name: "worker-3"}, true},
+		{Thread{Tid: 12, Name: "worker-x"}, false},
+		{Thread{Tid: 13, Name: "main"}, false},
+	}
+	for _, c := range cases {
+		if got := filter.Match(&c.th); got != c.match {
+			t.Errorf("Match(%d %q) = %t, expected %t", c.th.Tid, c.th.Name, got, c.match)
+		}
+	}
+}
+
+func TestHitBreakpoint(t *testing.T) {
+	tracer := Tracer{threads: make(map[int]*Thread)}
+	bp := &BreakPoint{Address: 0x1000}
+	tracer.threads[1] = &Thread{Tid: 1, Hits: make(map[uintptr]int)}
+	tracer.threads[2] = &Thread{Tid: 2, Hits: make(map[uintptr]int), Ignored: true}
+	tracer.threads[3] = &Thread{Tid: 3, Hits: make(map[uintptr]int)}
+
+	if !tracer.hitBreakpoint(1, bp) || tracer.hitBreakpoint(2, bp) {
+		t.Fatal("Ignored thread must not trigger the breakpoint")
+	}
+	bp.Filter = &ThreadFilter{Tids: map[int]bool{3: true}}
+	if tracer.hitBreakpoint(1, bp) || !tracer.hitBreakpoint(3, bp) {
+		t.Fatal("Filter not applied")
+	}
+	if bp.Hits != 2 || tracer.threads[1].Hits[0x1000] != 1 || tracer.threads[3].Hits[0x1000] != 1 {
+		t.Fatalf("Unexpected hit counts %d %v %v", bp.Hits, tracer.threads[1].Hits, tracer.threads[3].Hits)
+	}
+}
+
+func TestSetBreakpointThreadsProcesses(t *testing.T) {
+	parent, child := newAddressSpace(), newAddressSpace()
+	parent.breakpoints[0x1000] = &BreakPoint{Address: 0x1000}
+	child.breakpoints[0x1000] = &BreakPoint{Address: 0x1000}
+	tracer := &Tracer{session: &Session{root: 10, processes: map[int]*TracedProcess{
+		10: {Pid: 10, space: parent},
+		11: {Pid: 11, Parent: 10, space: child},
+		12: {Pid: 12, Parent: 10, space: child}, // vforked
+	}}}
+
+	if err := tracer.SetBreakpointThreads(0x1000, 11); err != nil {
+		t.Fatal(err)
+	}
+	for _, space := range []*addressSpace{parent, child} {
+		if f := space.breakpoints[0x1000].Filter; f == nil || !f.Tids[11] {
+			t.Errorf("Filter %+v", f)
+		}
+	}
+	if err := tracer.SetBreakpointThreads(0x2000, 11); err == nil {
+		t.Error("Filtered a breakpoint that doesn't exist")
+	}
+}
@@ -0,0 +1,432 @@
+package riptracer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// pendingEvent is a wait status collected while stopping threads that has to
+// be handled by the main loop later
+type pendingEvent struct {
+	pid int
+	ws  unix.WaitStatus
+}
+
+// stopThreads interrupts every traced thread except the one given, which is
+// expected to already be in a ptrace stop. It returns the threads that were
+// stopped and need to be passed to resumeThreads.
+func (t *Tracer) stopThreads(except int) []int {
+	stopped := make([]int, 0, len(t.threads))
+	interrupted := make([]int, 0, len(t.threads))
+
+	for tid := range t.threads {
+		if tid == except || t.isPending(tid) || t.threads[tid].vforking {
+			// A vfork parent can't stop before its child is done
+			continue
+		}
+		if err := unix.PtraceInterrupt(tid); err != nil {
+			// Not traced by us or already gone
+			continue
+		}
+		interrupted = append(interrupted, tid)
+	}
+
+	for _, tid := range interrupted {
+		var ws unix.WaitStatus
+		_, err := unix.Wait4(tid, &ws, unix.WALL, nil)
+		if err != nil {
+			if t.verbose {
+				log.Printf("Wait for stop of %d failed: %v", tid, err)
+			}
+			continue
+		}
+
+		if isEventStop(ws) && ws.StopSignal() == unix.SIGTRAP {
+			stopped = append(stopped, tid)
+			continue
+		}
+		// The thread stopped for something else first (breakpoint, exit, ...).
+		// It stays stopped until the main loop handles the event, the interrupt
+		// is reported and ignored after it is resumed.
+		t.pending = append(t.pending, pendingEvent{pid: tid, ws: ws})
+	}
+	return stopped
+}
+
+// seizeTracedChild turns a child stopped at exec by PTRACE_TRACEME into a
+// seized tracee, so its threads can be interrupted without signals. The
+// process doesn't run any of its own code in between.
+func seizeTracedChild(pid int) error {
+	// Detaching into a SIGSTOP keeps it stopped until we're attached again
+	if err := ptraceDetachSignal(pid, unix.SIGSTOP); err != nil {
+		return err
+	}
+	if err := ptraceSeize(pid, unix.PTRACE_O_TRACECLONE); err != nil {
+		return err
+	}
+	var ws unix.WaitStatus
+	if _, err := unix.Wait4(pid, &ws, unix.WALL, nil); err != nil {
+		return err
+	}
+
+	// End the group-stop, otherwise new threads start stopped. No signal
+	// handler can be installed yet and the SIGCONT is swallowed once reported.
+	if err := unix.Kill(pid, unix.SIGCONT); err != nil {
+		return err
+	}
+	for !ws.Stopped() || ws.StopSignal() != unix.SIGCONT {
+		if err := unix.PtraceCont(pid, 0); err != nil {
+			return err
+		}
+		if _, err := unix.Wait4(pid, &ws, unix.WALL, nil); err != nil {
+			return err
+		}
+		if ws.Exited() || ws.Signaled() {
+			return fmt.Errorf("Process %d exited while attaching", pid)
+		}
+	}
+	return nil
+}
+
+// attachAllThreads seizes and stops every thread of pid. The task list is
+// rescanned until a pass finds no new threads, as threads may be created
+// while we attach. Threads that exit in between are skipped.
+func attachAllThreads(procFS procfs.FS, pid int) (map[int]bool, error) {
+	threads := make(map[int]bool)
+	for {
+		tasks, err := procFS.AllThreads(pid)
+		if err != nil {
+			detachThreads(threads)
+			return nil, fmt.Errorf("Unable to list threads of %d: %v", pid, err)
+		}
+
+		added := false
+		for _, task := range tasks {
+			if threads[task.PID] {
+				continue
+			}
+			err := attachThread(task.PID)
+			if err == unix.ESRCH {
+				continue
+			}
+			if err != nil {
+				detachThreads(threads)
+				return nil, err
+			}
+			threads[task.PID] = true
+			added = true
+		}
+		if !added {
+			return threads, nil
+		}
+	}
+}
+
+// attachThread seizes a single thread with PTRACE_O_TRACECLONE and waits for
+// it to stop. ESRCH is returned if the thread exited.
+func attachThread(tid int) error {
+	err := ptraceSeize(tid, unix.PTRACE_O_TRACECLONE)
+	switch {
+	case err == unix.EPERM && isTracee(tid):
+		// Created by a thread we already attached to, it starts out stopped
+	case err == unix.EPERM:
+		return fmt.Errorf("Permissions error attaching to %d, please run as root: %v", tid, err)
+	case err != nil:
+		return err
+	default:
+		if err := unix.PtraceInterrupt(tid); err != nil {
+			return err
+		}
+	}
+
+	var ws unix.WaitStatus
+	for !ws.Stopped() {
+		if _, err := unix.Wait4(tid, &ws, unix.WALL, nil); err != nil {
+			if err == unix.ECHILD {
+				return unix.ESRCH
+			}
+			return err
+		}
+		if ws.Exited() || ws.Signaled() {
+			return unix.ESRCH
+		}
+	}
+	return nil
+}
+
+// isTracee reports whether tid is already traced by us
+func isTracee(tid int) bool {
+	var info unix.Siginfo
+	err := unix.Waitid(unix.P_PID, tid, &info, unix.WEXITED|unix.WSTOPPED|unix.WNOHANG|unix.WNOWAIT|unix.WALL, nil)
+	return err == nil
+}
+
+func detachThreads(threads map[int]bool) {
+	for tid := range threads {
+		unix.PtraceDetach(tid)
+	}
+}
+
+// isEventStop reports a PTRACE_EVENT_STOP, used for PTRACE_INTERRUPT, new
+// threads and (with a stop signal other than SIGTRAP) group-stops
+func isEventStop(ws unix.WaitStatus) bool {
+	return ws.Stopped() && uint32(ws)>>16 == unix.PTRACE_EVENT_STOP
+}
+
+func (t *Tracer) threadIDs() []int {
+	tids := make([]int, 0, len(t.threads))
+	for tid := range t.threads {
+		tids = append(tids, tid)
+	}
+	return tids
+}
+
+func (t *Tracer) resumeThreads(tids []int) {
+	for _, tid := range tids {
+		if err := t.continueThread(tid, 0); err != nil && t.verbose {
+			log.Printf("Failed to resume thread %d: %v", tid, err)
+		}
+	}
+}
+
+func (t *Tracer) isPending(pid int) bool {
+	for _, e := range t.pending {
+		if e.pid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// wait returns the next event, handing out events collected by stopThreads
+// first. While waiting it runs requests from other goroutines, ptrace only
+// accepts requests from the thread that attached. Events are held back while
+// the process is paused.
+func (t *Tracer) wait(ws *unix.WaitStatus, rusage *unix.Rusage) (int, error) {
+	for {
+		if t.paused == nil && len(t.pending) > 0 {
+			e := t.pending[0]
+			t.pending = t.pending[1:]
+			*ws = e.ws
+			return e.pid, nil
+		}
+
+		select {
+		case f := <-t.requests:
+			f()
+		case <-t.events:
+			var eventWs unix.WaitStatus
+			wpid, err := unix.Wait4(-1, &eventWs, unix.WALL|unix.WNOHANG, rusage)
+			t.eventAck <- struct{}{}
+			if err != nil {
+				return wpid, err
+			}
+			// 0 if the event was already collected by one of our own waits
+			if wpid > 0 {
+				t.pending = append(t.pending, pendingEvent{pid: wpid, ws: eventWs})
+			}
+		}
+	}
+}
+
+// watchEvents lets wait select between tracee events and requests. It only
+// peeks at events (WNOWAIT) and waits for wait to collect one before looking
+// again.
+func (t *Tracer) watchEvents() {
+	for {
+		var info unix.Siginfo
+		err := unix.Waitid(unix.P_ALL, 0, &info, unix.WEXITED|unix.WSTOPPED|unix.WNOWAIT|unix.WALL, nil)
+		select {
+		case t.events <- struct{}{}:
+		case <-t.done:
+			return
+		}
+		select {
+		case <-t.eventAck:
+		case <-t.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// request runs f on the tracer thread and waits for it to complete. It must
+// not be called from callbacks, they already run on the tracer thread.
+func (t *Tracer) request(f func()) {
+	finished := make(chan struct{})
+	select {
+	case t.requests <- func() { f(); close(finished) }:
+	case <-t.done:
+		return
+	}
+	select {
+	case <-finished:
+	case <-t.done:
+	}
+}
+
+// Pause stops all threads of the process without sending it signals, they
+// stay stopped until Resume. Events are handled after resuming.
+func (t *Tracer) Pause() {
+	t.request(func() {
+		if t.paused == nil {
+			t.paused = t.stopThreads(0)
+		}
+	})
+}
+
+func (t *Tracer) Resume() {
+	t.request(t.resume)
+}
+
+func (t *Tracer) resume() {
+	if t.paused != nil {
+		t.resumeThreads(t.paused)
+		t.paused = nil
+	}
+}
+
+// detachAll removes breakpoints and page protections and detaches from all
+// threads. current is the thread that reported ws, the last event.
+func (t *Tracer) detachAll(current int, ws unix.WaitStatus) {
+	t.stopThreads(current)
+
+	// Flushes the output of traces in progress
+	for tid := range t.traces {
+		t.endTrace(tid)
+	}
+
+	// Every address space has its own breakpoints and page protections
+	restored := make(map[*addressSpace]bool)
+	for _, tid := range append([]int{current}, t.threadIDs()...) {
+		space := t.processOf(tid).space
+		if _, traced := t.threads[tid]; !traced || restored[space] {
+			continue
+		}
+		restored[space] = true
+		t.unprotectPages(tid, space)
+		t.removeBreakpoints(tid, space)
+		space.protectedPages = make(map[uintptr]*protectedPage)
+	}
+
+	// Threads that trapped on a breakpoint have to run the original
+	// instruction when they continue, signals not delivered yet are passed on
+	signals := make(map[int][]unix.Signal)
+	for _, e := range append(t.pending, pendingEvent{pid: current, ws: ws}) {
+		if !e.ws.Stopped() || uint32(e.ws)>>16 != 0 {
+			continue
+		}
+		if e.ws.StopSignal() != unix.SIGTRAP {
+			signals[e.pid] = append(signals[e.pid], e.ws.StopSignal())
+			continue
+		}
+		if pc, err := GetReg(e.pid, "pc"); err == nil && t.processOf(e.pid).space.breakpoints[uintptr(pc)-1] != nil {
+			SetReg(e.pid, "pc", pc-1)
+		}
+	}
+	t.pending = nil
+	for tid, th := range t.threads {
+		for _, sig := range th.signals {
+			signals[tid] = append(signals[tid], unix.Signal(sig))
+		}
+		th.signals = nil
+	}
+
+	for tid, th := range t.threads {
+		var sig unix.Signal
+		if queued := signals[tid]; len(queued) > 0 {
+			// Only one signal can be passed along, the others are sent again
+			sig = queued[0]
+			for _, s := range queued[1:] {
+				unix.Tgkill(th.Pid, tid, s)
+			}
+		}
+		if err := ptraceDetachSignal(tid, sig); err != nil && t.verbose {
+			log.Printf("Detach from %d failed: %v", tid, err)
+		}
+	}
+}
+
+// errThreadGone is returned by stepThread if the thread exited, its exit is
+// handled by the main loop
+var errThreadGone = errors.New("Thread exited while stepping")
+
+// errStepEvent is returned by stepThread if the stepped system call reported a
+// ptrace event, e.g. of a fork or clone. The thread stays stopped at the event
+// until the main loop handles it and continues the thread.
+var errStepEvent = errors.New("Thread reported an event while stepping")
+
+// errStepExec is errStepEvent for an exec, the memory is a new program's then
+var errStepExec = errors.New("Thread exec'd while stepping")
+
+// leftToMainLoop reports whether stepThread failed with an event the main
+// loop handles, the caller must not continue the thread
+func leftToMainLoop(err error) bool {
+	return err == errThreadGone || err == errStepEvent || err == errStepExec
+}
+
+// stepThread single steps tid and waits for the step to complete. Our own
+// interrupts in the meantime are discarded, other ptrace events end the step
+// early. Signals are held back and delivered by the next continueThread, an
+// instruction that faults doesn't complete and its signal is returned as
+// error.
+func (t *Tracer) stepThread(tid int) error {
+	for {
+		if err := unix.PtraceSingleStep(tid); err != nil {
+			return err
+		}
+		var ws unix.WaitStatus
+		if _, err := unix.Wait4(tid, &ws, unix.WALL, nil); err != nil {
+			return err
+		}
+		if ws.Exited() || ws.Signaled() {
+			t.pending = append(t.pending, pendingEvent{pid: tid, ws: ws})
+			return errThreadGone
+		}
+		if isEventStop(ws) {
+			if t.verbose {
+				log.Printf("Discarding event 0x%x while stepping %d", uint32(ws)>>8, tid)
+			}
+			continue
+		}
+		if ws.TrapCause() > 0 {
+			t.pending = append(t.pending, pendingEvent{pid: tid, ws: ws})
+			if ws.TrapCause() == unix.PTRACE_EVENT_EXEC {
+				return errStepExec
+			}
+			return errStepEvent
+		}
+		sig := ws.StopSignal()
+		if sig == unix.SIGTRAP && !sentSignal(tid) {
+			return nil
+		}
+		th := t.addThread(tid, 0)
+		th.signals = append(th.signals, int(sig))
+		switch sig {
+		case unix.SIGSEGV, unix.SIGBUS, unix.SIGFPE, unix.SIGILL:
+			if !sentSignal(tid) {
+				return fmt.Errorf("Thread %d got %v while stepping", tid, sig)
+			}
+		}
+		if t.verbose {
+			log.Printf("Holding back signal %v while stepping %d", sig, tid)
+		}
+	}
+}
+
+// sentSignal reports whether the signal tid stopped with was sent by a
+// process (kill, tgkill, sigqueue) rather than raised by the CPU
+func sentSignal(tid int) bool {
+	info, err := ptraceGetSiginfo(tid)
+	if err != nil {
+		return false
+	}
+	return int32(binary.LittleEndian.Uint32(info[8:])) <= 0
+}
@@ -0,0 +1,260 @@
+package riptracer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// TestHelperThreadSpawner isn't a real test, it is started by
+// TestAttachAllThreads as a target that creates and exits threads constantly
+func TestHelperThreadSpawner(t *testing.T) {
+	if os.Getenv("RIPTRACER_THREAD_SPAWNER") == "" {
+		t.Skip("Helper process")
+	}
+	for {
+		done := make(chan struct{})
+		for i := 0; i < 8; i++ {
+			go func() {
+				// Exiting while locked terminates the thread
+				runtime.LockOSThread()
+				time.Sleep(time.Microsecond)
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			<-done
+		}
+	}
+}
+
+func TestAttachAllThreads(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperThreadSpawner")
+	cmd.Env = append(os.Environ(), "RIPTRACER_THREAD_SPAWNER=1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	time.Sleep(100 * time.Millisecond)
+
+	procFS, err := procfs.NewFS("/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		threads, err := attachAllThreads(procFS, cmd.Process.Pid)
+		if err != nil && strings.Contains(err.Error(), "Permissions") {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatalf("Attach %d failed: %v", i, err)
+		}
+
+		// Nothing can create threads while all of them are stopped
+		tasks, err := procFS.AllThreads(cmd.Process.Pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			if !threads[task.PID] {
+				t.Errorf("Attach %d missed thread %d", i, task.PID)
+			}
+			stat, err := task.Stat()
+			if err == nil && stat.State != "t" {
+				t.Errorf("Thread %d not stopped, state %s", task.PID, stat.State)
+			}
+		}
+
+		detachThreads(threads)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// launchTestTracer starts a process under a tracer locked to the calling
+// thread, which has to run Start
+func launchTestTracer(t *testing.T, opts LaunchOptions) *Tracer {
+	tracer, err := NewTracerLaunch(opts)
+	skipWithoutPtrace(t, err)
+	t.Cleanup(func() {
+		tracer.Process.Kill()
+		shutdownFlag = false
+	})
+	return tracer
+}
+
+// waitState polls the state of pid in /proc until it is one of states
+func waitState(pid int, states string) (string, error) {
+	procFS, err := procfs.NewFS("/proc")
+	if err != nil {
+		return "", err
+	}
+	for i := 0; ; i++ {
+		proc, err := procFS.Proc(pid)
+		if err != nil {
+			return "", err
+		}
+		stat, err := proc.Stat()
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(states, stat.State) || i == 100 {
+			return stat.State, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func tracerPid(pid int) string {
+	data, _ := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "TracerPid:") {
+			return strings.TrimSpace(line[len("TracerPid:"):])
+		}
+	}
+	return ""
+}
+
+func TestStopDetaches(t *testing.T) {
+	defer runtime.UnlockOSThread()
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{"/bin/sleep", "10"}})
+	pid := tracer.Process.Pid
+
+	// PTRACE_INTERRUPT only works on seized threads
+	if err := unix.PtraceInterrupt(pid); err != nil {
+		t.Fatalf("Launched process isn't seized: %v", err)
+	}
+	go func() {
+		waitState(pid, "S")
+		tracer.Stop()
+	}()
+	tracer.Start()
+
+	// No SIGSTOP was sent, the process keeps sleeping
+	if state, err := waitState(pid, "S"); err != nil || state != "S" {
+		t.Errorf("Process in state %s after Stop: %v", state, err)
+	}
+	if tracer := tracerPid(pid); tracer != "0" {
+		t.Errorf("Process still traced by %s", tracer)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	defer runtime.UnlockOSThread()
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{"/bin/sleep", "0.2"}})
+	pid := tracer.Process.Pid
+
+	paused := make(chan string)
+	go func() {
+		waitState(pid, "S")
+		tracer.Pause()
+		// Stays stopped after the sleep is over
+		time.Sleep(400 * time.Millisecond)
+		state, _ := waitState(pid, "t")
+		tracer.Resume()
+		paused <- state
+	}()
+	tracer.Start()
+	if state := <-paused; state != "t" {
+		t.Errorf("Paused process in state %s", state)
+	}
+}
+
+func TestGroupStopListen(t *testing.T) {
+	defer runtime.UnlockOSThread()
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{"/bin/sleep", "0.2"}})
+	pid := tracer.Process.Pid
+
+	exited := make(chan struct{})
+	stopped := make(chan bool)
+	go func() {
+		waitState(pid, "S")
+		unix.Kill(pid, unix.SIGSTOP)
+		waitState(pid, "tT")
+		// The group-stop lasts until SIGCONT, the tracer doesn't resume it
+		select {
+		case <-exited:
+			stopped <- false
+			return
+		case <-time.After(400 * time.Millisecond):
+		}
+		unix.Kill(pid, unix.SIGCONT)
+		stopped <- true
+	}()
+	tracer.Start()
+	close(exited)
+	if !<-stopped {
+		t.Error("Process ran during the group-stop")
+	}
+}
+
+const syscallProgram = `
+#include <sys/syscall.h>
+#include <sys/wait.h>
+#include <unistd.h>
+extern char **environ;
+__attribute__((noinline)) long run(long nr, void *a1, void *a2, void *a3) {
+	long ret;
+#ifdef __x86_64__
+	__asm__ volatile(".globl insn\n.type insn, @function\ninsn: syscall"
+		: "=a"(ret) : "a"(nr), "D"(a1), "S"(a2), "d"(a3) : "rcx", "r11", "memory");
+#else
+	__asm__ volatile(".globl insn\n.type insn, @function\ninsn: int $0x80"
+		: "=a"(ret) : "a"(nr), "b"(a1), "c"(a2), "d"(a3) : "memory");
+#endif
+	return ret;
+}
+int main(void) {
+	long pid = run(SYS_fork, 0, 0, 0);
+	if (pid == 0)
+		_exit(0);
+	waitpid(pid, 0, 0);
+	char *argv[] = {"echo", "ok", 0};
+	run(SYS_execve, "/bin/echo", argv, environ);
+	return 1;
+}
+`
+
+func TestStepOverForkAndExec(t *testing.T) {
+	prog := compileTestProgram(t, syscallProgram, "-O0")
+
+	for _, displaced := range []bool{false, true} {
+		var stdout bytes.Buffer
+		tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, Stdout: &stdout, StopAt: StopAtMain})
+		tracer.SetDisplacedStepping(displaced)
+		hits := 0
+		if err := tracer.Session().Root().SetBreakpointSymbol("insn", func(int, BreakPoint) { hits++ }); err != nil {
+			t.Fatal(err)
+		}
+		tracer.Start()
+		runtime.UnlockOSThread()
+
+		// The fork child has to be let go and echo must run unchanged
+		if hits != 2 || stdout.String() != "ok\n" {
+			t.Errorf("Displaced %t: %d hits, output %q", displaced, hits, stdout.String())
+		}
+	}
+}
+
+func TestStartReturnsAfterKill(t *testing.T) {
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{"/bin/sh", "-c", "kill -SEGV $$"}})
+	defer runtime.UnlockOSThread()
+
+	// Returns instead of failing to wait for the killed process
+	tracer.Start()
+	if len(tracer.threads) != 0 {
+		t.Errorf("Threads %v left", tracer.threadIDs())
+	}
+}
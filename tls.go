@@ -0,0 +1,269 @@
+package riptracer
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Value glibc stores in the DTV for blocks that haven't been allocated yet
+const tlsDTVUnallocated = ^uintptr(0)
+
+const wordSize = uintptr(ptrSize)
+
+type tlsModule struct {
+	path     string
+	modid    int
+	resolver *SymbolResolver
+}
+
+// ThreadPointer returns the thread pointer of a stopped thread, the address
+// of its TCB
+func ThreadPointer(tid int) (uintptr, error) {
+	return threadPointer(tid)
+}
+
+// StackCanary returns the stack protector value kept in the TCB of tid
+func (t *Tracer) StackCanary(tid int) (uintptr, error) {
+	tp, err := threadPointer(tid)
+	if err != nil {
+		return 0, err
+	}
+	return t.Memory(tid).ReadPointer(tp + tcbStackGuardOffset)
+}
+
+// TLSAddress returns the address of the TLS variable symbol in thread tid.
+// module selects the executable ("") or a loaded library by path or name
+// prefix, e.g. "libc" for libc.so.6.
+func (t *Tracer) TLSAddress(tid int, module string, symbol string) (uintptr, error) {
+	modules, err := t.tlsModules(tid)
+	if err != nil {
+		return 0, err
+	}
+
+	var mod *tlsModule
+	for i := range modules {
+		if matchModule(modules[i], module, i == 0) {
+			mod = &modules[i]
+			break
+		}
+	}
+	if mod == nil {
+		return 0, fmt.Errorf("No module with TLS matching %q", module)
+	}
+	if err := t.checkBuildID(mod.path, mod == &modules[0]); err != nil {
+		return 0, err
+	}
+
+	sym, err := mod.resolver.GetTLSSymbolByName(symbol)
+	if err != nil {
+		return 0, err
+	}
+
+	block, err := t.tlsBlock(tid, mod)
+	if err != nil {
+		return 0, err
+	}
+	return block + uintptr(sym.Value), nil
+}
+
+// ReadTLS reads len(data) bytes of the TLS variable symbol in thread tid
+func (t *Tracer) ReadTLS(tid int, module string, symbol string, data []byte) error {
+	addr, err := t.TLSAddress(tid, module, symbol)
+	if err != nil {
+		return err
+	}
+	_, err = t.Memory(tid).ReadAt(data, addr)
+	return err
+}
+
+// Errno returns the errno of thread tid, from libc or a static executable
+func (t *Tracer) Errno(tid int) (int32, error) {
+	addr, err := t.TLSAddress(tid, "libc", "errno")
+	if err != nil {
+		addr, err = t.TLSAddress(tid, "", "errno")
+		if err != nil {
+			return 0, err
+		}
+	}
+	value, err := t.Memory(tid).ReadUint32(addr)
+	return int32(value), err
+}
+
+func matchModule(mod tlsModule, module string, isExe bool) bool {
+	if module == "" {
+		return isExe
+	}
+	base := filepath.Base(mod.path)
+	return mod.path == module || base == module || strings.HasPrefix(base, module+".") || strings.HasPrefix(base, module+"-")
+}
+
+// tlsBlock returns the start of the TLS block of mod in thread tid. x86 uses
+// variant II of the ELF TLS ABI, the DTV in the TCB has the block of each
+// module by module id.
+func (t *Tracer) tlsBlock(tid int, mod *tlsModule) (uintptr, error) {
+	tp, err := threadPointer(tid)
+	if err != nil {
+		return 0, err
+	}
+
+	if mod.modid < 0 {
+		return 0, fmt.Errorf("%s has no TLS", mod.path)
+	}
+	if mod.modid == 0 {
+		// Statically linked, the executable's block is the only one
+		return tp - staticTLSOffset(mod.resolver.TLS), nil
+	}
+
+	mem := t.Memory(tid)
+	dtv, err := mem.ReadPointer(tp + tcbDTVOffset)
+	if err != nil {
+		return 0, err
+	}
+	// dtv[-1] is the number of entries, each entry is two words
+	count, err := mem.ReadPointer(dtv - wordSize*2)
+	if err != nil {
+		return 0, err
+	}
+	if uintptr(mod.modid) > count {
+		return 0, fmt.Errorf("Module %s (id %d) not in the DTV of %d", mod.path, mod.modid, tid)
+	}
+	block, err := mem.ReadPointer(dtv + uintptr(mod.modid)*wordSize*2)
+	if err != nil {
+		return 0, err
+	}
+	if block == tlsDTVUnallocated || block == 0 {
+		return 0, fmt.Errorf("TLS of %s not allocated yet in thread %d", mod.path, tid)
+	}
+	return block, nil
+}
+
+// staticTLSOffset is the distance between the thread pointer and the TLS
+// block of the first module, as laid out by glibc
+func staticTLSOffset(tls *elf.ProgHeader) uintptr {
+	align := tls.Align
+	if align == 0 {
+		align = 1
+	}
+	firstByte := -tls.Vaddr & (align - 1)
+	return uintptr((tls.Memsz-firstByte+align-1)&^(align-1) + firstByte)
+}
+
+// tlsModules lists the modules with a TLS segment in module id order. The
+// first entry is always the executable, with modid 0 if it is statically
+// linked. Ids follow the link map order like ld.so assigns them at startup,
+// ids reused after a dlclose aren't accounted for.
+func (t *Tracer) tlsModules(tid int) ([]tlsModule, error) {
+	exePath, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", t.processOf(tid).Pid))
+	if err != nil {
+		return nil, err
+	}
+	exe, err := t.resolverForPath(exePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if exe.Dynamic != 0 {
+		names, err = t.linkMap(tid, exePath, exe)
+	}
+	if exe.Dynamic == 0 || (err != nil && exe.TLS != nil) {
+		// Statically linked (possibly static-pie without DT_DEBUG)
+		if exe.TLS == nil {
+			return nil, fmt.Errorf("%s has no TLS", exePath)
+		}
+		return []tlsModule{{path: exePath, resolver: exe}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make([]tlsModule, 0)
+	if exe.TLS == nil {
+		// Keep the executable first so "" never matches a library
+		modules = append(modules, tlsModule{path: exePath, modid: -1, resolver: exe})
+	}
+	modid := 0
+	for i, name := range names {
+		resolver := exe
+		if i > 0 {
+			// The vdso and anything else we can't open has no TLS for us
+			if resolver, err = t.resolverForPath(name); err != nil {
+				continue
+			}
+		}
+		if resolver.TLS == nil {
+			continue
+		}
+		modid++
+		path := name
+		if i == 0 {
+			path = exePath
+		}
+		modules = append(modules, tlsModule{path: path, modid: modid, resolver: resolver})
+	}
+	return modules, nil
+}
+
+// linkMap walks the dynamic linker's list of loaded objects, found through
+// DT_DEBUG of the executable, and returns their names in load order
+func (t *Tracer) linkMap(tid int, exePath string, exe *SymbolResolver) ([]string, error) {
+	procMaps, err := t.processOf(tid).MemMaps()
+	if err != nil {
+		return nil, err
+	}
+	var start uintptr
+	for _, m := range procMaps {
+		if m.Pathname == exePath && (start == 0 || m.StartAddr < start) {
+			start = m.StartAddr
+		}
+	}
+	if start == 0 {
+		return nil, fmt.Errorf("%s not mapped", exePath)
+	}
+	bias := start - uintptr(exe.LoadAddr)
+
+	mem := t.Memory(tid)
+	var rDebug uintptr
+	for dyn := bias + uintptr(exe.Dynamic); ; dyn += wordSize * 2 {
+		tag, err := mem.ReadPointer(dyn)
+		if err != nil {
+			return nil, err
+		}
+		if tag == uintptr(elf.DT_NULL) {
+			break
+		}
+		if tag == uintptr(elf.DT_DEBUG) {
+			if rDebug, err = mem.ReadPointer(dyn + wordSize); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if rDebug == 0 {
+		return nil, fmt.Errorf("Dynamic linker hasn't set up r_debug yet")
+	}
+
+	// struct r_debug { int r_version; struct link_map *r_map; ... }
+	lm, err := mem.ReadPointer(rDebug + wordSize)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	// struct link_map { l_addr, l_name, l_ld, l_next, l_prev }
+	for lm != 0 && len(names) < 4096 {
+		namePtr, err := mem.ReadPointer(lm + wordSize)
+		if err != nil {
+			return nil, err
+		}
+		name, _, _ := mem.ReadCString(namePtr, 4096)
+		names = append(names, name)
+		if lm, err = mem.ReadPointer(lm + wordSize*3); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,55 @@
+//go:build 386
+// +build 386
+
+package riptracer
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into glibc's tcbhead_t
+const (
+	tcbDTVOffset        = 4
+	tcbStackGuardOffset = 0x14
+)
+
+// struct user_desc from asm/ldt.h
+type userDesc struct {
+	EntryNumber uint32
+	BaseAddr    uint32
+	Limit       uint32
+	Flags       uint32
+}
+
+// threadPointer returns the base of the gs segment, which libc points at the TCB
+func threadPointer(tid int) (uintptr, error) {
+	base, _, err := segmentBase(tid, "gs_base")
+	return uintptr(base), err
+}
+
+// segmentBase looks up fs_base and gs_base in the GDT entry of the selector,
+// they aren't part of PtraceRegs on 386
+func segmentBase(tid int, name string) (uint64, bool, error) {
+	var regs unix.PtraceRegs
+	switch name {
+	case "fs_base", "gs_base":
+	default:
+		return 0, false, nil
+	}
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return 0, true, err
+	}
+	selector := regs.Xgs
+	if name == "fs_base" {
+		selector = regs.Xfs
+	}
+	if selector == 0 {
+		return 0, true, nil
+	}
+
+	var desc userDesc
+	err := ptrace(unix.PTRACE_GET_THREAD_AREA, tid, uintptr(selector>>3), uintptr(unsafe.Pointer(&desc)))
+	return uint64(desc.BaseAddr), true, err
+}
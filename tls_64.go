@@ -0,0 +1,26 @@
+//go:build amd64
+// +build amd64
+
+package riptracer
+
+import "golang.org/x/sys/unix"
+
+// Offsets into glibc's tcbhead_t
+const (
+	tcbDTVOffset        = 8
+	tcbStackGuardOffset = 0x28
+)
+
+func threadPointer(tid int) (uintptr, error) {
+	var regs unix.PtraceRegs
+	if err := unix.PtraceGetRegs(tid, &regs); err != nil {
+		return 0, err
+	}
+	return uintptr(regs.Fs_base), nil
+}
+
+// segmentBase handles segment base registers missing from PtraceRegs, on
+// amd64 fs_base and gs_base are part of it
+func segmentBase(tid int, name string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,88 @@
+package riptracer
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStaticTLSOffset(t *testing.T) {
+	tests := []struct {
+		vaddr, memsz, align uint64
+		want                uintptr
+	}{
+		{0x3df0, 0x10, 8, 0x10},
+		{0x3df0, 0x74, 8, 0x78},
+		{0x3df0, 0x74, 0x40, 0x90},
+		{0x3df8, 0x4, 0x10, 0x8},
+		{0x1000, 0x4, 0, 0x4},
+	}
+	for _, tt := range tests {
+		tls := &elf.ProgHeader{Vaddr: tt.vaddr, Memsz: tt.memsz, Align: tt.align}
+		if got := staticTLSOffset(tls); got != tt.want {
+			t.Errorf("staticTLSOffset(vaddr=%x memsz=%x align=%x) = %x, want %x", tt.vaddr, tt.memsz, tt.align, got, tt.want)
+		}
+	}
+}
+
+func TestMatchModule(t *testing.T) {
+	libc := tlsModule{path: "/lib/x86_64-linux-gnu/libc.so.6"}
+	for _, name := range []string{"libc", "libc.so.6", "/lib/x86_64-linux-gnu/libc.so.6"} {
+		if !matchModule(libc, name, false) {
+			t.Errorf("%q should match %s", name, libc.path)
+		}
+	}
+	for _, name := range []string{"", "lib", "libcrypto"} {
+		if matchModule(libc, name, false) {
+			t.Errorf("%q shouldn't match %s", name, libc.path)
+		}
+	}
+	if !matchModule(tlsModule{path: "/tmp/a.out"}, "", true) {
+		t.Errorf("empty module should match the executable")
+	}
+}
+
+const tlsProgram = `
+#include <errno.h>
+#include <unistd.h>
+__thread int value = 1;
+__attribute__((noinline)) void check(void) { __asm__ volatile("" ::: "memory"); }
+int main(void) {
+	value = 0x5678;
+	close(-1);
+	check();
+	return 0;
+}
+`
+
+func TestReadTLS(t *testing.T) {
+	prog := compileTestProgram(t, tlsProgram, "-O0")
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, StopAt: StopAtMain})
+	defer runtime.UnlockOSThread()
+
+	checked := false
+	err := tracer.Session().Root().SetBreakpointSymbol("check", func(tid int, bp BreakPoint) {
+		checked = true
+		if errno, err := tracer.Errno(tid); err != nil || errno != int32(unix.EBADF) {
+			t.Errorf("errno %d: %v", errno, err)
+		}
+		value := make([]byte, 4)
+		if err := tracer.ReadTLS(tid, "", "value", value); err != nil || binary.LittleEndian.Uint32(value) != 0x5678 {
+			t.Errorf("TLS variable % x: %v", value, err)
+		}
+		// glibc clears the low byte to stop string functions at the canary
+		if canary, err := tracer.StackCanary(tid); err != nil || canary == 0 || canary&0xff != 0 {
+			t.Errorf("Stack canary 0x%x: %v", canary, err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+	if !checked {
+		t.Error("Breakpoint not hit")
+	}
+}
@@ -0,0 +1,373 @@
+package riptracer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/arch/x86/x86asm"
+	"golang.org/x/sys/unix"
+)
+
+// Handlers run below the red zone (amd64) and a signal frame (386) of this
+// size, frames above it are unwound when the signal wasn't handled
+const signalFrameGap = 128
+
+// TraceEvent is an instruction executed by a traced thread
+type TraceEvent struct {
+	Tid         int
+	Instruction Instruction
+	Changes     []RegisterChange // Registers the instruction changed, without the pc
+}
+
+type TraceFunction func(TraceEvent)
+
+// TraceOptions changes what TraceRange records
+type TraceOptions struct {
+	// StepOverCalls leaves out calls into other modules, including the code
+	// they call back. The call is reported with the changes of the whole call.
+	StepOverCalls bool
+	// Output receives the events in the compact format read by
+	// NewTraceReader. It is flushed whenever a thread leaves the range.
+	Output io.Writer
+}
+
+type rangeTrace struct {
+	start, end uintptr
+	module     string
+	cb         TraceFunction
+	opts       TraceOptions
+	out        *traceWriter
+}
+
+type traceFrame struct {
+	sp     uintptr // Stack pointer before the call
+	silent bool
+}
+
+// activeTrace is a thread stepping through a range
+type activeTrace struct {
+	trace    *rangeTrace
+	pending  *Instruction // Next instruction, nil while silent
+	regs     *Registers   // Registers before pending
+	frames   []traceFrame
+	held     *Instruction // Call reported once its silent frame ends
+	heldRegs *Registers
+	procMaps []*procfs.ProcMap
+}
+
+// TraceRange single steps every thread that executes start until it leaves
+// [start, end) and passes cb the instructions it executed, each once it ran.
+// Calls made from the range are traced as well, until the stack is back above
+// their return address. That also ends frames left by longjmp and exceptions.
+func (t *Tracer) TraceRange(start uintptr, end uintptr, cb TraceFunction) error {
+	return t.TraceRangeOptions(start, end, cb, TraceOptions{})
+}
+
+func (t *Tracer) TraceRangeOptions(start uintptr, end uintptr, cb TraceFunction, opts TraceOptions) error {
+	if start >= end {
+		return fmt.Errorf("Empty trace range 0x%x-0x%x", start, end)
+	}
+	root := t.session.Root()
+	procMaps, err := root.MemMaps()
+	if err != nil {
+		return err
+	}
+	m := findMapping(procMaps, start)
+	if m == nil {
+		return fmt.Errorf("Trace range start 0x%x is not mapped", start)
+	}
+
+	trace := &rangeTrace{start: start, end: end, module: m.Pathname, cb: cb, opts: opts}
+	if opts.Output != nil {
+		meta, err := root.Metadata()
+		if err != nil {
+			return err
+		}
+		if trace.out, err = newTraceWriter(opts.Output, meta); err != nil {
+			return err
+		}
+	}
+	return root.setBreakpoint(start, func(tid int, bp BreakPoint) {
+		if err := t.startTrace(tid, trace); err != nil {
+			log.Printf("%sUnable to trace %d at 0x%x: %v%s", Red, tid, start, err, Reset)
+		}
+	})
+}
+
+// startTrace runs as callback of the breakpoint at the start of the range,
+// the main loop steps the first instruction and calls traceStep
+func (t *Tracer) startTrace(tid int, trace *rangeTrace) error {
+	if _, ok := t.traces[tid]; ok {
+		// Recursion or a jump back to the start
+		return nil
+	}
+	procMaps, err := t.processOf(tid).MemMaps()
+	if err != nil {
+		return err
+	}
+	insts, err := t.disassemble(tid, trace.start, 1, procMaps)
+	if err != nil {
+		return err
+	}
+	regs, err := GetRegisters(tid)
+	if err != nil {
+		return err
+	}
+	if trace.out != nil {
+		trace.out.enter(tid, regs)
+	}
+	if t.verbose {
+		log.Printf("Tracing %d from 0x%x", tid, trace.start)
+	}
+	t.traces[tid] = &activeTrace{trace: trace, pending: &insts[0], regs: regs, procMaps: procMaps}
+	return nil
+}
+
+// traceStep handles the stop of a traced thread after a single step and
+// resumes it. It reports false if tid isn't traced.
+func (t *Tracer) traceStep(tid int) bool {
+	a, ok := t.traces[tid]
+	if !ok {
+		return false
+	}
+	if bp := t.hwBreakpointHit(tid); bp != nil {
+		// The instruction at the breakpoint runs with the next step
+		t.runCallbacks(tid, bp)
+		check(t.continueThread(tid, 0))
+		return true
+	}
+	for {
+		done, err := t.advanceTrace(tid, a)
+		if err != nil {
+			log.Printf("%sTrace of %d stopped: %v%s", Red, tid, err, Reset)
+			done = true
+		}
+		if done {
+			t.endTrace(tid)
+			check(t.continueThread(tid, 0))
+			return true
+		}
+		bp, ok := t.processOf(tid).space.breakpoints[uintptr(a.regs.Values[registerAliases["pc"]])]
+		if !ok {
+			check(t.continueThread(tid, 0))
+			return true
+		}
+		// Stepping onto the int3 would be taken for a step
+		if err := t.stepBreakpoint(tid, bp); err != nil {
+			log.Printf("%sTrace of %d stopped: %v%s", Red, tid, err, Reset)
+			t.endTrace(tid)
+			if !leftToMainLoop(err) {
+				check(t.continueThread(tid, 0))
+			}
+			return true
+		}
+	}
+}
+
+// advanceTrace reports the instruction tid executed and decodes the next one.
+// It reports true once the thread left the range.
+func (t *Tracer) advanceTrace(tid int, a *activeTrace) (bool, error) {
+	regs, err := GetRegisters(tid)
+	if err != nil {
+		return false, err
+	}
+	pc, _ := regs.Get("pc")
+	sp, _ := regs.Get("sp")
+
+	if a.silent() {
+		a.unwind(uintptr(sp))
+		if a.silent() {
+			a.regs = regs
+			return false, nil
+		}
+		if a.held != nil {
+			a.emit(tid, a.held, a.heldRegs, regs)
+			a.held, a.heldRegs = nil, nil
+		}
+	} else if p := a.pending; p != nil {
+		a.unwind(uintptr(sp))
+		if isCall(p) && uintptr(pc) != p.Address+uintptr(len(p.Bytes)) {
+			a.frames = append(a.frames, traceFrame{sp: uintptr(sp) + wordSize})
+		}
+		if a.trace.opts.StepOverCalls && len(a.frames) > 0 && t.foreignCode(tid, a, uintptr(pc)) {
+			a.frames[len(a.frames)-1].silent = true
+			a.held, a.heldRegs = p, a.regs
+		} else {
+			a.emit(tid, p, a.regs, regs)
+		}
+	}
+	a.pending, a.regs = nil, regs
+
+	if len(a.frames) == 0 && (uintptr(pc) < a.trace.start || uintptr(pc) >= a.trace.end) {
+		return true, nil
+	}
+	if a.silent() {
+		return false, nil
+	}
+	insts, err := t.disassemble(tid, uintptr(pc), 1, a.procMaps)
+	if err != nil {
+		return false, err
+	}
+	a.pending = &insts[0]
+	return false, nil
+}
+
+// stepBreakpoint steps tid over a breakpoint at its pc and runs its
+// callbacks like the main loop does
+func (t *Tracer) stepBreakpoint(tid int, bp *BreakPoint) error {
+	t.runCallbacks(tid, bp)
+	t.replaceCode(tid, bp.Address, *bp.OriginalCode)
+	err := t.stepThread(tid)
+	if err != errStepExec {
+		t.replaceCode(tid, bp.Address, []byte{0xCC})
+	}
+	return err
+}
+
+// runCallbacks counts a hit of bp by tid and runs its callbacks, with the
+// other threads stopped in all-stop mode
+func (t *Tracer) runCallbacks(tid int, bp *BreakPoint) {
+	var stopped []int
+	if t.allStop {
+		stopped = t.stopThreads(tid)
+	}
+	defer t.resumeThreads(stopped)
+
+	if t.hitBreakpoint(tid, bp) {
+		for _, cb := range bp.Callbacks {
+			cb(tid, *bp)
+		}
+	}
+}
+
+// hwBreakpointHit returns the hardware breakpoint tid stopped on, nil if the
+// stop was a step. A step onto the breakpoint isn't a hit, the next one is.
+func (t *Tracer) hwBreakpointHit(tid int) *BreakPoint {
+	if len(t.hwbreakpoints) == 0 {
+		return nil
+	}
+	pc, err := GetReg(tid, "pc")
+	if err != nil {
+		return nil
+	}
+	bp, ok := t.hwbreakpoints[uintptr(pc)]
+	if !ok {
+		return nil
+	}
+	dr6 := make([]byte, 8)
+	if _, err := unix.PtracePeekUser(tid, uintptr(DR_OFFSET+(REG_SIZE*6)), dr6); err != nil {
+		return nil
+	}
+	// The status of DR1, see setHWBreakpoint
+	if bytesToUint64(dr6)&(1<<1) == 0 {
+		return nil
+	}
+	unix.PtracePokeUser(tid, uintptr(DR_OFFSET+(REG_SIZE*6)), uintptrToBytes(0))
+	return bp
+}
+
+// continueThread resumes tid with sig or a signal held back while stepping,
+// traced threads keep stepping
+func (t *Tracer) continueThread(tid int, sig int) error {
+	if th, ok := t.threads[tid]; ok && sig == 0 && len(th.signals) > 0 {
+		sig, th.signals = th.signals[0], th.signals[1:]
+	}
+	var err error
+	if a, ok := t.traces[tid]; ok {
+		if sig != 0 && !a.silent() && signalCaught(tid, sig) {
+			// The handler runs before the pending instruction
+			sp, _ := a.regs.Get("sp")
+			a.frames = append(a.frames, traceFrame{sp: uintptr(sp) - signalFrameGap, silent: true})
+			a.pending = nil
+		}
+		err = ptrace(unix.PTRACE_SINGLESTEP, tid, 0, uintptr(sig))
+	} else {
+		err = unix.PtraceCont(tid, sig)
+	}
+	if err == unix.ESRCH && t.isPending(tid) {
+		// Exited while we were stepping it, the exit is handled next
+		return nil
+	}
+	return err
+}
+
+// endTrace stops tracing tid, the thread is left as it is
+func (t *Tracer) endTrace(tid int) {
+	a, ok := t.traces[tid]
+	if !ok {
+		return
+	}
+	delete(t.traces, tid)
+	if t.verbose {
+		log.Printf("Trace of %d ended", tid)
+	}
+	if a.trace.out != nil {
+		if err := a.trace.out.flush(); err != nil {
+			log.Printf("%sUnable to write the trace: %v%s", Red, err, Reset)
+		}
+	}
+}
+
+// foreignCode reports whether pc is outside the module of the range
+func (t *Tracer) foreignCode(tid int, a *activeTrace, pc uintptr) bool {
+	m := findMapping(a.procMaps, pc)
+	if m == nil {
+		// Loaded while tracing
+		if procMaps, err := t.processOf(tid).MemMaps(); err == nil {
+			a.procMaps = procMaps
+			m = findMapping(procMaps, pc)
+		}
+	}
+	return m == nil || m.Pathname != a.trace.module
+}
+
+func (a *activeTrace) silent() bool {
+	return len(a.frames) > 0 && a.frames[len(a.frames)-1].silent
+}
+
+// unwind drops the frames that returned
+func (a *activeTrace) unwind(sp uintptr) {
+	for len(a.frames) > 0 && sp >= a.frames[len(a.frames)-1].sp {
+		a.frames = a.frames[:len(a.frames)-1]
+	}
+}
+
+func (a *activeTrace) emit(tid int, inst *Instruction, before *Registers, after *Registers) {
+	event := TraceEvent{Tid: tid, Instruction: *inst, Changes: make([]RegisterChange, 0)}
+	for _, c := range before.Diff(after) {
+		if c.Name != registerAliases["pc"] {
+			event.Changes = append(event.Changes, c)
+		}
+	}
+	if a.trace.out != nil {
+		a.trace.out.event(event)
+	}
+	if a.trace.cb != nil {
+		a.trace.cb(event)
+	}
+}
+
+func isCall(inst *Instruction) bool {
+	decoded, err := decodeInstruction(inst.Bytes, disasmMode)
+	return err == nil && decoded.Op == x86asm.CALL
+}
+
+// signalCaught reports whether the process of tid has a handler for sig
+func signalCaught(tid int, sig int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", tid))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "SigCgt:") {
+			caught, err := strconv.ParseUint(strings.TrimSpace(line[len("SigCgt:"):]), 16, 64)
+			return err == nil && caught&(1<<(sig-1)) != 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package riptracer
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"golang.org/x/arch/x86/x86asm"
+	"golang.org/x/sys/unix"
+)
+
+func TestTraceFileRoundTrip(t *testing.T) {
+	var regs unix.PtraceRegs
+	writeRegister(&regs, "pc", 0x1000)
+	writeRegister(&regs, "sp", 0x8000)
+	sp := registerAliases["sp"]
+
+	var buf bytes.Buffer
+	tw, err := newTraceWriter(&buf, &TraceMetadata{Pid: 42, Exe: "/bin/true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.enter(7, newRegisters(7, &regs))
+	written := []TraceEvent{
+		// push %rbp / push %ebp
+		{Tid: 7, Instruction: decodeAt([]byte{0x55}, 0x1000), Changes: []RegisterChange{{Name: sp, Old: 0x8000, New: 0x8000 - uint64(wordSize)}}},
+		// Another thread that didn't enter, its old values are unknown
+		{Tid: 8, Instruction: decodeAt([]byte{0x90}, 0x2000), Changes: []RegisterChange{}},
+		// jmp back to the start, ret
+		{Tid: 7, Instruction: decodeAt([]byte{0xeb, 0xfd}, 0x1001), Changes: []RegisterChange{}},
+		{Tid: 7, Instruction: decodeAt([]byte{0xc3}, 0x1000), Changes: []RegisterChange{{Name: sp, Old: 0x8000 - uint64(wordSize), New: 0x8000}}},
+	}
+	for _, e := range written {
+		tw.event(e)
+	}
+	if err := tw.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTraceReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Header.Metadata.Pid != 42 || len(tr.Header.Registers) != len(registerNames) {
+		t.Errorf("Header %+v", tr.Header)
+	}
+	for i, want := range written {
+		got, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Event %d: %v", i, err)
+		}
+		if got.Tid != want.Tid || got.Instruction.String() != want.Instruction.String() {
+			t.Errorf("Event %d: %d %s, expected %d %s", i, got.Tid, got.Instruction, want.Tid, want.Instruction)
+		}
+		if len(got.Changes) != len(want.Changes) {
+			t.Fatalf("Event %d: changes %v, expected %v", i, got.Changes, want.Changes)
+		}
+		for j := range got.Changes {
+			if got.Changes[j] != want.Changes[j] {
+				t.Errorf("Event %d: change %v, expected %v", i, got.Changes[j], want.Changes[j])
+			}
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Expected the end of the trace, got %v", err)
+	}
+}
+
+func TestTraceFileInvalid(t *testing.T) {
+	if _, err := NewTraceReader(bytes.NewReader([]byte("ELF"))); err == nil {
+		t.Error("Read a trace from garbage")
+	}
+}
+
+const traceProgram = `
+#include <string.h>
+static const char *volatile word = "hello";
+__attribute__((noinline)) int leaf(int x) { return x * 3; }
+__attribute__((noinline)) int traced(int x) { return leaf(x) + (int)strlen(word); }
+int main(void) { return traced(4) == 17 ? 0 : 1; }
+`
+
+// symbolRange returns where a function of the executable of p is in memory
+func symbolRange(t *testing.T, p *TracedProcess, name string) (uintptr, uintptr) {
+	resolver, err := p.Resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym, err := resolver.GetSymbolByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bias, err := p.LoadBias()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bias + uintptr(sym.Value), bias + uintptr(sym.Value+sym.Size)
+}
+
+// traceFunction traces traced() in prog until the program exits or stop
+// returns true, it checks the trace file has the same events
+func traceFunction(t *testing.T, prog string, opts TraceOptions, stop func(TraceEvent) bool) (*TracedProcess, []*procfs.ProcMap, []TraceEvent) {
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, StopAt: StopAtMain})
+	defer runtime.UnlockOSThread()
+
+	root := tracer.Session().Root()
+	start, end := symbolRange(t, root, "traced")
+	procMaps, err := root.MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []TraceEvent
+	var out bytes.Buffer
+	opts.Output = &out
+	err = tracer.TraceRangeOptions(start, end, func(e TraceEvent) {
+		events = append(events, e)
+		if stop != nil && stop(e) {
+			tracer.Stop()
+		}
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+
+	tr, err := NewTraceReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		e, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Trace file ends after %d of %d events: %v", i, len(events), err)
+		}
+		if e.Instruction.Address != events[i].Instruction.Address {
+			t.Fatalf("Event %d in the file at 0x%x, expected 0x%x", i, e.Instruction.Address, events[i].Instruction.Address)
+		}
+	}
+	return root, procMaps, events
+}
+
+func op(e TraceEvent) x86asm.Op {
+	inst, _ := decodeInstruction(e.Instruction.Bytes, disasmMode)
+	return inst.Op
+}
+
+func TestTraceRange(t *testing.T) {
+	prog := compileTestProgram(t, traceProgram, "-O0", "-fno-builtin")
+
+	for _, stepOver := range []bool{false, true} {
+		root, procMaps, events := traceFunction(t, prog, TraceOptions{StepOverCalls: stepOver}, nil)
+		start, end := symbolRange(t, root, "traced")
+		leaf, leafEnd := symbolRange(t, root, "leaf")
+		if len(events) == 0 || events[0].Instruction.Address != start {
+			t.Fatalf("StepOverCalls %t: trace doesn't start at 0x%x: %v", stepOver, start, events)
+		}
+		if last := events[len(events)-1]; op(last) != x86asm.RET || last.Instruction.Address < start || last.Instruction.Address >= end {
+			t.Errorf("StepOverCalls %t: trace ends with %s", stepOver, last.Instruction)
+		}
+
+		inLeaf, foreign := 0, 0
+		exe := findMapping(procMaps, start).Pathname
+		for i, e := range events {
+			addr := e.Instruction.Address
+			if addr >= leaf && addr < leafEnd {
+				inLeaf++
+			}
+			if m := findMapping(procMaps, addr); m == nil || m.Pathname != exe {
+				foreign++
+			}
+			if op(e) == x86asm.PUSH {
+				sp := registerAliases["sp"]
+				if len(e.Changes) != 1 || e.Changes[0].Name != sp || e.Changes[0].New != e.Changes[0].Old-uint64(wordSize) {
+					t.Errorf("Changes of %s: %v", e.Instruction, e.Changes)
+				}
+			}
+			// The instruction after the call to leaf is traced once it returned
+			if e.Instruction.Target == leaf && op(e) == x86asm.CALL {
+				if i+1 >= len(events) || events[i+1].Instruction.Address != leaf {
+					t.Errorf("StepOverCalls %t: leaf not traced after %s", stepOver, e.Instruction)
+				}
+				next := addr + uintptr(len(e.Instruction.Bytes))
+				found := false
+				for _, r := range events[i+1:] {
+					found = found || r.Instruction.Address == next
+				}
+				if !found {
+					t.Errorf("StepOverCalls %t: nothing traced after leaf returned to 0x%x", stepOver, next)
+				}
+			}
+		}
+		if inLeaf == 0 {
+			t.Errorf("StepOverCalls %t: leaf not traced", stepOver)
+		}
+		// strlen is in libc, with StepOverCalls only its PLT stub is traced
+		if stepOver != (foreign == 0) {
+			t.Errorf("StepOverCalls %t: traced %d instructions of other modules", stepOver, foreign)
+		}
+	}
+}
+
+func TestTraceRangeStopFlushes(t *testing.T) {
+	prog := compileTestProgram(t, traceProgram, "-O0", "-fno-builtin")
+
+	// traceFunction checks the file has every event
+	_, _, events := traceFunction(t, prog, TraceOptions{}, func(e TraceEvent) bool { return op(e) == x86asm.CALL })
+	if len(events) == 0 || op(events[len(events)-1]) != x86asm.CALL {
+		t.Errorf("Trace went on after Stop: %v", events)
+	}
+}
+
+func TestTraceRangeHWBreakpoint(t *testing.T) {
+	prog := compileTestProgram(t, traceProgram, "-O0", "-fno-builtin")
+	tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, StopAt: StopAtMain})
+	defer runtime.UnlockOSThread()
+
+	root := tracer.Session().Root()
+	start, end := symbolRange(t, root, "traced")
+	leaf, _ := symbolRange(t, root, "leaf")
+	hits, traced := 0, 0
+	tracer.SetHWBreakpointAbsolute(leaf, func(int, BreakPoint) { hits++ })
+	err := tracer.TraceRange(start, end, func(e TraceEvent) {
+		if e.Instruction.Address == leaf {
+			traced++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Start()
+
+	// The hit must neither be taken for a step nor get lost
+	if hits != 1 || traced != 1 {
+		t.Errorf("Breakpoint hit %d times, instruction traced %d times", hits, traced)
+	}
+}
@@ -0,0 +1,225 @@
+package riptracer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// Trace files start with traceMagic and the length prefixed JSON TraceHeader,
+// followed by records of a kind byte and varints
+const traceMagic = "RIPTRC1\n"
+
+const (
+	// tid, the value of every register in header order
+	traceRecordEnter = 1
+	// tid, the address as delta to the previous one of the thread, length
+	// and bytes of the instruction, the count of changes and a register index
+	// byte and the new value of each. The reader restores the old values and
+	// the disassembly.
+	traceRecordStep = 2
+)
+
+// TraceHeader describes the process a trace file was recorded from
+type TraceHeader struct {
+	Arch      string
+	Registers []string
+	Metadata  *TraceMetadata
+}
+
+type traceWriter struct {
+	w       *bufio.Writer
+	index   map[string]int
+	lastPCs map[int]uintptr
+}
+
+func newTraceWriter(w io.Writer, meta *TraceMetadata) (*traceWriter, error) {
+	header, err := json.Marshal(TraceHeader{Arch: runtime.GOARCH, Registers: registerNames, Metadata: meta})
+	if err != nil {
+		return nil, err
+	}
+	tw := &traceWriter{w: bufio.NewWriter(w), index: make(map[string]int), lastPCs: make(map[int]uintptr)}
+	for idx, name := range registerNames {
+		tw.index[name] = idx
+	}
+	tw.w.WriteString(traceMagic)
+	tw.uvarint(uint64(len(header)))
+	tw.w.Write(header)
+	return tw, nil
+}
+
+func (tw *traceWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	tw.w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+// enter records the registers of tid as it starts into the range
+func (tw *traceWriter) enter(tid int, regs *Registers) {
+	tw.w.WriteByte(traceRecordEnter)
+	tw.uvarint(uint64(tid))
+	for _, name := range registerNames {
+		tw.uvarint(regs.Values[name])
+	}
+	pc, _ := regs.Get("pc")
+	tw.lastPCs[tid] = uintptr(pc)
+}
+
+func (tw *traceWriter) event(e TraceEvent) {
+	var buf [binary.MaxVarintLen64]byte
+	tw.w.WriteByte(traceRecordStep)
+	tw.uvarint(uint64(e.Tid))
+	delta := int64(e.Instruction.Address - tw.lastPCs[e.Tid])
+	tw.w.Write(buf[:binary.PutVarint(buf[:], delta)])
+	tw.lastPCs[e.Tid] = e.Instruction.Address
+	tw.uvarint(uint64(len(e.Instruction.Bytes)))
+	tw.w.Write(e.Instruction.Bytes)
+	tw.uvarint(uint64(len(e.Changes)))
+	for _, c := range e.Changes {
+		tw.w.WriteByte(byte(tw.index[c.Name]))
+		tw.uvarint(c.New)
+	}
+}
+
+func (tw *traceWriter) flush() error {
+	return tw.w.Flush()
+}
+
+// TraceReader reads the events of a trace file written by TraceRangeOptions
+type TraceReader struct {
+	Header  TraceHeader
+	r       *bufio.Reader
+	regs    map[int][]uint64
+	lastPCs map[int]uintptr
+}
+
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	tr := &TraceReader{r: bufio.NewReader(r), regs: make(map[int][]uint64), lastPCs: make(map[int]uintptr)}
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(tr.r, magic); err != nil || string(magic) != traceMagic {
+		return nil, fmt.Errorf("Not a trace file")
+	}
+	length, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, length)
+	if _, err := io.ReadFull(tr.r, header); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(header, &tr.Header); err != nil {
+		return nil, fmt.Errorf("Invalid trace header: %v", err)
+	}
+	if len(tr.Header.Registers) > 256 {
+		return nil, fmt.Errorf("Invalid trace header: %d registers", len(tr.Header.Registers))
+	}
+	return tr, nil
+}
+
+// Next returns the next event, io.EOF at the end of the trace
+func (tr *TraceReader) Next() (TraceEvent, error) {
+	for {
+		kind, err := tr.r.ReadByte()
+		if err != nil {
+			return TraceEvent{}, err
+		}
+		switch kind {
+		case traceRecordEnter:
+			if err := tr.readEnter(); err != nil {
+				return TraceEvent{}, tr.truncated(err)
+			}
+		case traceRecordStep:
+			e, err := tr.readStep()
+			return e, tr.truncated(err)
+		default:
+			return TraceEvent{}, fmt.Errorf("Unknown trace record %d", kind)
+		}
+	}
+}
+
+func (tr *TraceReader) truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (tr *TraceReader) readEnter() error {
+	tid, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return err
+	}
+	values := make([]uint64, len(tr.Header.Registers))
+	for idx := range values {
+		if values[idx], err = binary.ReadUvarint(tr.r); err != nil {
+			return err
+		}
+	}
+	tr.regs[int(tid)] = values
+	for idx, name := range tr.Header.Registers {
+		if name == registerAliases["pc"] {
+			tr.lastPCs[int(tid)] = uintptr(values[idx])
+		}
+	}
+	return nil
+}
+
+func (tr *TraceReader) readStep() (TraceEvent, error) {
+	tid, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return TraceEvent{}, err
+	}
+	delta, err := binary.ReadVarint(tr.r)
+	if err != nil {
+		return TraceEvent{}, err
+	}
+	length, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return TraceEvent{}, err
+	}
+	if length == 0 || length > maxInsnLength {
+		return TraceEvent{}, fmt.Errorf("Invalid instruction length %d in trace", length)
+	}
+	code := make([]byte, length)
+	if _, err := io.ReadFull(tr.r, code); err != nil {
+		return TraceEvent{}, err
+	}
+	count, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return TraceEvent{}, err
+	}
+	if count > uint64(len(tr.Header.Registers)) {
+		return TraceEvent{}, fmt.Errorf("Invalid count of %d register changes in trace", count)
+	}
+
+	e := TraceEvent{Tid: int(tid), Changes: make([]RegisterChange, 0, count)}
+	addr := tr.lastPCs[e.Tid] + uintptr(delta)
+	tr.lastPCs[e.Tid] = addr
+	e.Instruction = decodeAt(code, addr)
+	// decodeAt shows bytes it can't decode one by one
+	e.Instruction.Bytes = code
+
+	regs, ok := tr.regs[e.Tid]
+	if !ok {
+		regs = make([]uint64, len(tr.Header.Registers))
+		tr.regs[e.Tid] = regs
+	}
+	for i := uint64(0); i < count; i++ {
+		idx, err := tr.r.ReadByte()
+		if err != nil {
+			return TraceEvent{}, err
+		}
+		value, err := binary.ReadUvarint(tr.r)
+		if err != nil {
+			return TraceEvent{}, err
+		}
+		if int(idx) >= len(regs) {
+			return TraceEvent{}, fmt.Errorf("Invalid register %d in trace", idx)
+		}
+		e.Changes = append(e.Changes, RegisterChange{Name: tr.Header.Registers[idx], Old: regs[idx], New: value})
+		regs[idx] = value
+	}
+	return e, nil
+}
@@ -5,7 +5,6 @@ package riptracer
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"log"
 	"os"
@@ -27,21 +26,44 @@ type BreakPoint struct {
 	OriginalCode *[]byte
 	Hits         int
 	Callbacks    []CallBackFunction
+	Prototype    *FunctionPrototype
+	Filter       *ThreadFilter // Only these threads trigger the callbacks
+	Pid          int           // Process and thread of the last hit
+	Tid          int
+	// Only used by watchpoints
+	Size          int
+	AccessAddress uintptr
 }
 
 type Tracer struct {
-	Process          *os.Process
-	ProcFS           procfs.FS
-	ws               unix.WaitStatus
-	breakpoints      map[uintptr]*BreakPoint
-	hwbreakpoints    map[uintptr]*BreakPoint
-	threads          map[int]bool
-	ignoredPids      map[int]bool
-	verbose          bool
-	exeCompareLength int
-	baseAddress      uintptr
-	ptraceOptions    int
-	interactive      bool
+	Process           *os.Process
+	cmd               *exec.Cmd // Set if we launched the process
+	ProcFS            procfs.FS
+	ws                unix.WaitStatus
+	hwbreakpoints     map[uintptr]*BreakPoint
+	pagewatchpoints   map[uintptr]*BreakPoint
+	activeTid         int
+	threads           map[int]*Thread
+	session           *Session
+	breakpointSpecs   []BreakpointSpec
+	buildIDs          map[string]string    // Pinned build-id by module, "" for any executable
+	traces            map[int]*activeTrace // Threads stepping through a TraceRange
+	newChildren       map[int]bool         // Forked children seen before their parent's event
+	followForks       bool
+	verbose           bool
+	ptraceOptions     int
+	interactive       bool
+	allStop           bool
+	displacedStepping bool
+	prototypes        map[uintptr]*FunctionPrototype
+	memory            map[int]*Memory
+	resolvers         map[string]*SymbolResolver
+	pending           []pendingEvent
+	requests          chan func()
+	done              chan struct{}
+	events            chan struct{}
+	eventAck          chan struct{}
+	paused            []int
 }
 
 func check(err error) {
@@ -51,92 +73,76 @@ func check(err error) {
 }
 
 // How many bytes we want to use to compare mem to executable
+//
+// Deprecated: the base address is computed from the auxiliary vector and the
+// ELF headers.
 const DEFAULTEXECMPLENGTH = 32
 
 var shutdownFlag = false
 
-func readBytesFromFile(filePath string, length int, offset int64) []byte {
-	f, err := os.Open(filePath)
-	check(err)
-
-	_, err = f.Seek(offset, 0)
-	check(err)
-
-	data := make([]byte, length)
-	numBytes, err := f.Read(data)
-	check(err)
-	if length != numBytes {
-		log.Fatalln("Couldn't read expected number of bytes from exe file")
-	}
-
-	return data
-}
-
-func _attachToPid(pid int) (int, error) {
-	err := unix.PtraceAttach(pid)
-	if err == unix.EPERM {
-		_, err := unix.PtraceGetEventMsg(pid)
-		if err != nil {
-			log.Fatalln("Permissions Error attaching to PID, please run as root, err message: ", err)
-			return 0, err
-		}
-	} else if err != nil {
-		log.Fatalln("Error attaching to pid:", err)
-		return 0, err
-	}
-
-	return pid, nil
+// NewTracerStartCommand starts cmd_str split on spaces with our stdio
+func NewTracerStartCommand(cmd_str string) (*Tracer, error) {
+	return NewTracerLaunch(LaunchOptions{
+		Args:   strings.Split(cmd_str, " "),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
 }
 
-func NewTracerStartCommand(cmd_str string) (*Tracer, error) {
+// NewTracerLaunch starts a process as described by opts, stopped until Start
+func NewTracerLaunch(opts LaunchOptions) (*Tracer, error) {
 	runtime.LockOSThread()
-	threads := make(map[int]bool)
 
-	cmds := strings.Split(cmd_str, " ")
-
-	cmd := exec.Command(cmds[0], cmds[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &unix.SysProcAttr{Ptrace: true}
-	check(cmd.Start())
-
-	cmd.Wait() //Ignore the error, we hit our starting breakpoint trap
+	cmd, err := launchTraced(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	check(unix.PtraceSetOptions(cmd.Process.Pid, unix.PTRACE_O_TRACECLONE))
 
-	log.Printf("CMD PID: %s : %v\n", cmd_str, cmd.Process.Pid)
+	log.Printf("CMD PID: %s : %v\n", strings.Join(opts.Args, " "), cmd.Process.Pid)
 	unix.PtraceSingleStep(cmd.Process.Pid)
 
 	var ws unix.WaitStatus
 	wpid, err := unix.Wait4(cmd.Process.Pid, &ws, unix.WALL, nil)
 
-	// Add this pid to known threads. We need to continue this pid once breakpoints are set.
-	threads[wpid] = true
-
 	procFS, err := procfs.NewFS("/proc")
 	if err != nil {
 		log.Fatalln("Couldn't access proc fs", err)
 		return nil, err
 	}
 
-	return &Tracer{
-		Process:          cmd.Process,
-		ProcFS:           procFS,
-		breakpoints:      make(map[uintptr]*BreakPoint),
-		hwbreakpoints:    make(map[uintptr]*BreakPoint, 1),
-		threads:          threads,
-		exeCompareLength: DEFAULTEXECMPLENGTH,
-		baseAddress:      0,
-		ptraceOptions:    unix.PTRACE_O_TRACECLONE,
-		ignoredPids:      make(map[int]bool),
-		interactive:      false,
-	}, nil
-
+	tracer := Tracer{
+		Process:         cmd.Process,
+		ProcFS:          procFS,
+		cmd:             cmd,
+		hwbreakpoints:   make(map[uintptr]*BreakPoint, 1),
+		pagewatchpoints: make(map[uintptr]*BreakPoint),
+		threads:         make(map[int]*Thread),
+		newChildren:     make(map[int]bool),
+		ptraceOptions:   tracerPtraceOptions,
+		interactive:     false,
+		prototypes:      make(map[uintptr]*FunctionPrototype),
+		memory:          make(map[int]*Memory),
+		resolvers:       make(map[string]*SymbolResolver),
+		buildIDs:        make(map[string]string),
+		traces:          make(map[int]*activeTrace),
+		requests:        make(chan func()),
+		done:            make(chan struct{}),
+	}
+	tracer.session = newSession(&tracer, wpid)
+	// Add this pid to known threads. We need to continue this pid once breakpoints are set.
+	tracer.addThread(wpid, 0)
+	if err := tracer.stopAt(opts.StopAt); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	return &tracer, nil
 }
 
 func NewTracerFromPid(pid int) (*Tracer, error) {
-	var ws unix.WaitStatus
 	runtime.LockOSThread()
 
 	procFS, err := procfs.NewFS("/proc")
@@ -150,36 +156,34 @@ func NewTracerFromPid(pid int) (*Tracer, error) {
 		log.Fatalf("Failed to find process: %s\n", err)
 	}
 
-	all_pids, err := procFS.AllThreads(pid)
-	check(err)
+	threads, err := attachAllThreads(procFS, pid)
+	if err != nil {
+		return nil, err
+	}
 
 	tracer := Tracer{
-		Process:          proc,
-		ProcFS:           procFS,
-		breakpoints:      make(map[uintptr]*BreakPoint),
-		hwbreakpoints:    make(map[uintptr]*BreakPoint, 1),
-		threads:          make(map[int]bool),
-		exeCompareLength: DEFAULTEXECMPLENGTH,
-		baseAddress:      0,
-		ptraceOptions:    unix.PTRACE_O_TRACECLONE,
-		ignoredPids:      make(map[int]bool),
-		interactive:      false,
+		Process:         proc,
+		ProcFS:          procFS,
+		hwbreakpoints:   make(map[uintptr]*BreakPoint, 1),
+		pagewatchpoints: make(map[uintptr]*BreakPoint),
+		threads:         make(map[int]*Thread),
+		newChildren:     make(map[int]bool),
+		ptraceOptions:   tracerPtraceOptions,
+		interactive:     false,
+		prototypes:      make(map[uintptr]*FunctionPrototype),
+		memory:          make(map[int]*Memory),
+		resolvers:       make(map[string]*SymbolResolver),
+		buildIDs:        make(map[string]string),
+		traces:          make(map[int]*activeTrace),
+		requests:        make(chan func()),
+		done:            make(chan struct{}),
 	}
-
-	for i := range all_pids {
-		p := all_pids[i].PID
-
-		_attachToPid(p)
-
-		for {
-			_, err := unix.Wait4(p, &ws, unix.WALL, nil)
-			if ws.Stopped() {
-				check(err)
-				break
-			}
-			time.Sleep(time.Millisecond * 100)
-		}
-		tracer.threads[p] = true
+	tracer.session = newSession(&tracer, pid)
+	if randomized, err := tracer.session.Root().Randomized(); err == nil {
+		log.Printf("Address randomization of %d: %t", pid, randomized)
+	}
+	for tid := range threads {
+		tracer.addThread(tid, 0)
 	}
 	return &tracer, nil
 }
@@ -188,22 +192,30 @@ func (t *Tracer) EnableVerbose() {
 	t.verbose = true
 }
 
-func (t *Tracer) SetExeComparisonLength(length int) {
-	t.exeCompareLength = length
-}
-func (t *Tracer) SetFollowForks(enable bool) {
+// Deprecated: the base address is computed from the auxiliary vector and the
+// ELF headers, the length is ignored.
+func (t *Tracer) SetExeComparisonLength(length int) {}
 
-	if enable {
-		t.ptraceOptions = t.ptraceOptions | unix.PTRACE_EVENT_FORK | unix.PTRACE_EVENT_VFORK
-	} else {
-		t.ptraceOptions = t.ptraceOptions & ^(unix.PTRACE_EVENT_FORK | unix.PTRACE_EVENT_VFORK)
+// SetFollowForks traces child processes created by fork, vfork and clone,
+// each with a copy of its parent's breakpoints. Otherwise the breakpoints are
+// removed from children before they are detached.
+func (t *Tracer) SetFollowForks(enable bool) {
+	t.followForks = enable
+	if t.verbose {
+		log.Printf("SetFollowForks: %t", enable)
 	}
+}
 
+// SetAllStop makes the tracer stop every other thread while a breakpoint or
+// watchpoint is handled, so no thread can run past a breakpoint while it is
+// temporarily removed and callbacks see a consistent process state
+func (t *Tracer) SetAllStop(enable bool) {
+	t.allStop = enable
 	if t.verbose {
-		log.Printf("SetFollowForks: %t, 0x%x", enable, t.ptraceOptions)
+		log.Printf("SetAllStop: %t", enable)
 	}
-
 }
+
 func (t *Tracer) SetInteractive(enable bool) {
 
 	if enable {
@@ -232,7 +244,9 @@ func (t *Tracer) Start() {
 			case unix.SIGINT:
 				log.Println("Got SIGINT SIGNAL")
 				if t.interactive {
+					t.Pause()
 					t.input()
+					t.Resume()
 				} else {
 					t.Stop()
 				}
@@ -248,44 +262,44 @@ func (t *Tracer) Start() {
 		}
 	}()
 
+	t.events = make(chan struct{})
+	t.eventAck = make(chan struct{})
+	defer close(t.done)
+	go t.watchEvents()
+
 	// At this point breakpoints should be configured. Let's continue all threads...
 	t.continueAllThreads()
 
 	for {
 		var rusage unix.Rusage
 		// Wait for any trap from any thread
-		wpid, err := unix.Wait4(-1, &ws, unix.WALL, &rusage)
+		wpid, err := t.wait(&ws, &rusage)
 		if t.verbose {
 			log.Printf("PPID:%d / PID:%d wait4 returned... 0x%x, %v, %v, %v\n", t.Process.Pid, wpid, ws, ws.StopSignal(), ws.TrapCause(), err)
 			log.Printf("-> signal: 0x%x\n", (ws>>8)&0xFF)
 		}
 		check(err)
+		t.activeTid = wpid
 
 		if shutdownFlag {
 			log.Printf("%sDisable all breakpoints... %s", Red, Reset)
-			for b := range t.breakpoints {
-				breakPoint := t.breakpoints[b]
-				replaceCode(wpid, breakPoint.Address, *breakPoint.OriginalCode)
-			}
 			log.Printf("%sDetaching from Process...%s and return\n", Red, Reset)
-			unix.PtraceDetach(t.Process.Pid)
+			t.detachAll(wpid, ws)
 			return
 		}
 
-		// Check whether it's a pid we're ignoring
-		if t.ignoredPids[wpid] {
-			check(unix.PtraceCont(wpid, 0))
+		if t.holdNewChild(wpid, ws) {
 			continue
 		}
-
-		t.threads[wpid] = true
+		t.addThread(wpid, 0)
 
 		if ws.Exited() == true {
-			delete(t.threads, wpid)
+			t.removeThread(wpid)
 			if t.verbose {
 				log.Printf("Child pid %v finished.\n", wpid)
 			}
 			if len(t.threads) == 0 {
+				t.waitLaunched()
 				break
 			}
 			continue
@@ -295,7 +309,11 @@ func (t *Tracer) Start() {
 			if t.verbose {
 				log.Printf("Error: Other pid(%v) signalled %v", wpid, ws)
 			}
-			delete(t.threads, wpid)
+			t.removeThread(wpid)
+			if len(t.threads) == 0 {
+				t.waitLaunched()
+				break
+			}
 			continue
 		}
 
@@ -316,108 +334,175 @@ func (t *Tracer) Start() {
 			if t.verbose {
 				log.Printf("Ptrace exit event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_CLONE << 8):
 			if t.verbose {
 				log.Printf("Ptrace clone event detected pid %v ", wpid)
 			}
-			newPid := t.getEventMsg(wpid)
-			t.threads[int(newPid)] = true
-			check(unix.PtraceCont(wpid, 0))
+			newPid := int(t.getEventMsg(wpid))
+			if t.tgidOf(newPid) == newPid {
+				// Without CLONE_THREAD it's a new process
+				t.handleNewProcess(wpid, newPid, false)
+			} else {
+				// The new thread may have reported its first stop already
+				t.addThread(newPid, wpid).Parent = wpid
+			}
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_FORK << 8):
 			if t.verbose {
 				log.Printf("PTrace fork event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			t.handleNewProcess(wpid, int(t.getEventMsg(wpid)), false)
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_VFORK << 8):
 			if t.verbose {
 				log.Printf("Ptrace vfork event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			t.handleNewProcess(wpid, int(t.getEventMsg(wpid)), true)
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_VFORK_DONE << 8):
 			if t.verbose {
 				log.Printf("Ptrace vfork done event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			t.vforkDone(wpid)
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_EXEC << 8):
 			if t.verbose {
 				log.Printf("Ptrace exec event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			t.execProcess(wpid)
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGTRAP) | (unix.PTRACE_EVENT_STOP << 8):
 			if t.verbose {
 				log.Printf("Ptrace stop event detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			check(t.continueThread(wpid, 0))
+
+		case uint32(unix.SIGSTOP) | (unix.PTRACE_EVENT_STOP << 8),
+			uint32(unix.SIGTSTP) | (unix.PTRACE_EVENT_STOP << 8),
+			uint32(unix.SIGTTIN) | (unix.PTRACE_EVENT_STOP << 8),
+			uint32(unix.SIGTTOU) | (unix.PTRACE_EVENT_STOP << 8):
+			if t.verbose {
+				log.Printf("Group-stop detected pid %v ", wpid)
+			}
+			// Stay stopped until SIGCONT, but keep reporting to us. SIGCONT
+			// resumes the thread without a step, so its trace can't go on.
+			t.endTrace(wpid)
+			check(ptraceListen(wpid))
 
 		case uint32(unix.SIGTRAP):
+			if t.traceStep(wpid) {
+				continue
+			}
 			if t.verbose {
 				log.Printf("SIGTRAP/Breakpoint detected in pid %v ", wpid)
 			}
 
-			breakPoint, hwOk := t.hwbreakpoints[uintptr(regs.Eip)]
-			if hwOk {
-				breakPoint.Hits += 1
+			hwBreakPoint, hwOk := t.hwbreakpoints[uintptr(regs.Eip)]
+			breakPoint, ok := t.processOf(wpid).space.breakpoints[uintptr(regs.Eip)-1]
+
+			// In all-stop mode nothing else runs while callbacks run and the
+			// breakpoint is removed
+			var stopped []int
+			gone := false
+			if t.allStop && (hwOk || ok) {
+				stopped = t.stopThreads(wpid)
+			}
+
+			if hwOk && t.hitBreakpoint(wpid, hwBreakPoint) {
 				if t.verbose {
 					msgId := t.getEventMsg(wpid)
-					log.Printf("PID: %d (msg:%d) Hit Breakpoint at 0x%x (%d times)", wpid, msgId, breakPoint.Address, breakPoint.Hits)
+					log.Printf("PID: %d (msg:%d) Hit Breakpoint at 0x%x (%d times)", wpid, msgId, hwBreakPoint.Address, hwBreakPoint.Hits)
 				}
 				// Call the callback print handlers
-				for idx := range breakPoint.Callbacks {
-					cb := breakPoint.Callbacks[idx]
-					cb(wpid, *breakPoint)
+				for idx := range hwBreakPoint.Callbacks {
+					cb := hwBreakPoint.Callbacks[idx]
+					cb(wpid, *hwBreakPoint)
 				}
 			}
 
-			breakPoint, ok := t.breakpoints[uintptr(regs.Eip)-1]
 			if ok {
-				breakPoint.Hits += 1
-				if t.verbose {
+				run := t.hitBreakpoint(wpid, breakPoint)
+				if t.verbose && run {
 					msgId := t.getEventMsg(wpid)
 					log.Printf("PID: %d (msg:%d) Hit Breakpoint at 0x%x (%d times)", wpid, msgId, breakPoint.Address, breakPoint.Hits)
 				}
 
-				replaceCode(wpid, breakPoint.Address, *breakPoint.OriginalCode)
+				if !t.displacedStepping {
+					t.replaceCode(wpid, breakPoint.Address, *breakPoint.OriginalCode)
+				}
 				regs.Eip = int32(breakPoint.Address)
 				check(unix.PtraceSetRegs(wpid, &regs))
 
 				// Call the callback print handlers
-				for idx := range breakPoint.Callbacks {
-					cb := breakPoint.Callbacks[idx]
-					cb(wpid, *breakPoint)
+				if run {
+					for idx := range breakPoint.Callbacks {
+						cb := breakPoint.Callbacks[idx]
+						cb(wpid, *breakPoint)
+					}
 				}
 
-				// we need to step forward once before setting the breakpoint again
-				check(unix.PtraceSingleStep(wpid))
-				wpid, err = unix.Wait4(wpid, &ws, unix.WALL, nil)
-				check(err)
-				// set the breakpoint back again
-				replaceCode(wpid, breakPoint.Address, []byte{0xCC})
+				stepped := false
+				if t.displacedStepping {
+					stepped, err = t.displacedStep(wpid, breakPoint.Address)
+					if stepped && err != nil {
+						log.Printf("Displaced step at 0x%x in %d failed: %v", breakPoint.Address, wpid, err)
+						gone = leftToMainLoop(err)
+					} else if err != nil {
+						log.Printf("Displaced step at 0x%x failed, stepping in place: %v", breakPoint.Address, err)
+						t.replaceCode(wpid, breakPoint.Address, *breakPoint.OriginalCode)
+					}
+				}
+				if !stepped {
+					// we need to step forward once before setting the breakpoint again.
+					// A SIGSTOP queued by all-stop mode must not cut the step short.
+					err := t.stepThread(wpid)
+					if err != nil {
+						log.Printf("Step over breakpoint at 0x%x in %d failed: %v", breakPoint.Address, wpid, err)
+						gone = leftToMainLoop(err)
+					}
+					// set the breakpoint back again, unless it's a new program now
+					if err != errStepExec {
+						t.replaceCode(wpid, breakPoint.Address, []byte{0xCC})
+					}
+				}
 			} else {
 				if t.verbose {
 					log.Printf("Got SIGTRAP without known Breakpoint at 0x%x\n", regs.Eip)
 				}
 			}
-			check(unix.PtraceCont(wpid, 0))
+			t.resumeThreads(stopped)
+			// The breakpoint may have started a trace, its first step is done
+			if !gone && !t.traceStep(wpid) {
+				check(t.continueThread(wpid, 0))
+			}
 
 		case uint32(unix.SIGCHLD):
 			if t.verbose {
 				log.Printf("SIGCHLD detected pid %v ", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			check(t.continueThread(wpid, 0))
 
 		case uint32(unix.SIGSTOP):
 			if t.verbose {
-				msg := t.getEventMsg(wpid)
-				log.Printf("SIGSTOP detected pid %v, msg: %v ", wpid, msg)
+				log.Printf("SIGSTOP detected pid %v", wpid)
 			}
-			check(unix.PtraceCont(wpid, 0))
+			// Nothing of ours sends SIGSTOP, deliver it so the group-stop happens
+			check(t.continueThread(wpid, int(unix.SIGSTOP)))
+		case uint32(unix.SIGSEGV):
+			if t.handlePageFault(wpid) {
+				check(t.continueThread(wpid, 0))
+			} else {
+				log.Printf("SIGSEGV in pid %d", wpid)
+				check(t.continueThread(wpid, int(unix.SIGSEGV)))
+			}
+
 		case uint32(unix.SIGINT):
 			if wpid == t.Process.Pid {
 				log.Printf("SIGINT on PID %d, Start detaching and exit", wpid)
@@ -428,13 +513,13 @@ func (t *Tracer) Start() {
 				os.Exit(0)
 			} else {
 				log.Printf("SIGINT on child PID %d", wpid)
-				check(unix.PtraceCont(wpid, 0))
+				check(t.continueThread(wpid, 0))
 			}
 
 		default:
 			y := ws.StopSignal()
 			log.Printf("Child stopped for unknown reasons pid %v status %v signal %d", wpid, ws, y)
-			check(unix.PtraceCont(wpid, int(ws.StopSignal())))
+			check(t.continueThread(wpid, int(ws.StopSignal())))
 		}
 
 	}
@@ -446,7 +531,10 @@ func (t *Tracer) continueAllThreads() {
 			log.Printf("Setting configuration on pid: %d", p)
 		}
 		check(unix.PtraceSetOptions(p, t.ptraceOptions))
-		check(unix.PtraceCont(p, 0))
+		// Threads with a pending event are continued once it is handled
+		if !t.isPending(p) {
+			check(unix.PtraceCont(p, 0))
+		}
 	}
 }
 
@@ -456,96 +544,52 @@ func (*Tracer) getEventMsg(wpid int) uint {
 	return msgID
 }
 
+// GetBaseAddress returns where the executable of the traced process is mapped
 func (t *Tracer) GetBaseAddress() (uintptr, error) {
-	if t.baseAddress > 0 {
-		return t.baseAddress, nil
-	} else {
-		p, err := t.ProcFS.Proc(t.Process.Pid)
-
-		if err != nil {
-			log.Fatalln(err)
-		}
-		procMaps, err := p.ProcMaps()
-		exePath := fmt.Sprintf("/proc/%d/exe", t.Process.Pid)
-		exeMemPath := fmt.Sprintf("/proc/%d/mem", t.Process.Pid)
-
-		exeData := readBytesFromFile(exePath, t.exeCompareLength, 0)
-
-		for i := range procMaps {
-			// Only check if we're at a base address
-			if 0 == procMaps[i].Offset {
-				if int(procMaps[i].EndAddr-procMaps[i].StartAddr) > t.exeCompareLength {
-					memData := readBytesFromFile(exeMemPath, t.exeCompareLength, int64(procMaps[i].StartAddr))
-
-					if 0 == bytes.Compare(exeData, memData) {
-						if t.verbose {
-							log.Printf("start:%x offset:%x, pathname: %s", procMaps[i].StartAddr, procMaps[i].Offset, procMaps[i].Pathname)
-						}
-						t.baseAddress = procMaps[i].StartAddr
-						return t.baseAddress, nil
-					}
-				}
-			}
-		}
-	}
+	return t.session.Root().BaseAddress()
+}
 
-	log.Fatalln("Unable to find the base address of the process")
-	return 0, nil
+// FileOffsetToAddress returns where the byte at an offset of the executable
+// file of the traced process is in memory
+func (t *Tracer) FileOffsetToAddress(offset uint64) (uintptr, error) {
+	return t.session.Root().FileOffsetToAddress(offset)
 }
 
 func (t *Tracer) GetMemMaps() ([]*procfs.ProcMap, error) {
-	p, err := t.ProcFS.Proc(t.Process.Pid)
-
-	if err != nil {
-		log.Fatalln(err)
-	}
-	procMaps, err := p.ProcMaps()
-
-	return procMaps, err
+	return t.session.Root().MemMaps()
 }
 
-func replaceCode(pid int, breakpoint uintptr, code []byte) []byte {
+func (t *Tracer) replaceCode(pid int, breakpoint uintptr, code []byte) []byte {
+	mem := t.Memory(pid)
 	original := make([]byte, len(code))
-	_, err := unix.PtracePeekData(pid, breakpoint, original)
+	_, err := mem.ReadAt(original, breakpoint)
 	check(err)
-	//log.Printf("peek: cnt: %d, err: %v, original %v", cnt, err, original)
 
-	_, err = unix.PtracePokeData(pid, breakpoint, code)
-	//log.Printf("poke: cnt: %d, err: %v", cnt, err)
+	_, err = mem.WriteAt(code, breakpoint)
 	check(err)
 
 	return original
 }
 
+// ConvertOffsetToAddress adds the base address of the traced process to an
+// offset from its first segment.
+//
+// Deprecated: use RelativeAddress, this returns 0 if the executable doesn't
+// have the pinned build-id.
 func (t *Tracer) ConvertOffsetToAddress(breakAddress uintptr) uintptr {
-	baseAddress, err := t.GetBaseAddress()
+	bp, err := t.RelativeAddress(breakAddress)
 	if err != nil {
-		log.Fatalln(err)
+		log.Println(err)
+		return 0
 	}
-
-	bp := baseAddress + breakAddress
 	return bp
 }
 
-func (t *Tracer) setBreakpoint(breakAddress uintptr, cb CallBackFunction) {
-	bp := breakAddress
-
-	breakpoint, ok := t.breakpoints[bp]
-
-	if ok {
-		log.Printf("Breakpoint at 0x%x already set, adding cb...", bp)
-		breakpoint.Callbacks = append(breakpoint.Callbacks, cb)
-	} else {
-		log.Printf("Setting Breakpoint at 0x%x", bp)
-		org := replaceCode(t.Process.Pid, bp, []byte{0xCC})
-
-		callBacks := make([]CallBackFunction, 0)
-		callBacks = append(callBacks, cb)
-
-		t.breakpoints[bp] = &BreakPoint{Address: bp, OriginalCode: &org, Hits: 0, Callbacks: callBacks}
-	}
-
-	return
+// RelativeAddress adds the base address of the traced process to an offset
+// from its first segment, refused if the executable doesn't have the pinned
+// build-id
+func (t *Tracer) RelativeAddress(offset uintptr) (uintptr, error) {
+	return t.session.Root().RelativeAddress(offset)
 }
 
 // https://en.wikipedia.org/wiki/X86_debug_register
@@ -589,37 +633,94 @@ func (t *Tracer) setHWBreakpoint(breakAddress uintptr, cb CallBackFunction) {
 		callBacks := make([]CallBackFunction, 0)
 		callBacks = append(callBacks, cb)
 
-		t.hwbreakpoints[bp] = &BreakPoint{Address: bp, OriginalCode: nil, Hits: 0, Callbacks: callBacks}
+		t.hwbreakpoints[bp] = &BreakPoint{Address: bp, OriginalCode: nil, Hits: 0, Callbacks: callBacks, Prototype: t.prototypes[bp]}
 	}
 
 	return
 }
 
-func (t *Tracer) SetBreakpointRelative(breakAddress uintptr, cb CallBackFunction) {
-	bp := t.ConvertOffsetToAddress(breakAddress)
-	t.setBreakpoint(bp, cb)
+// SetBreakpointRelative sets a breakpoint at an offset from the base address
+// of the traced process. The address must be the start of an instruction in
+// executable memory.
+func (t *Tracer) SetBreakpointRelative(breakAddress uintptr, cb CallBackFunction) error {
+	return t.session.Root().SetBreakpointRelative(breakAddress, cb)
 }
 
-func (t *Tracer) SetBreakpointAbsolute(breakAddress uintptr, cb CallBackFunction) {
-	t.setBreakpoint(breakAddress, cb)
+func (t *Tracer) SetBreakpointAbsolute(breakAddress uintptr, cb CallBackFunction) error {
+	return t.session.Root().SetBreakpointAbsolute(breakAddress, cb)
 }
 
-func (t *Tracer) SetHWBreakpointRelative(breakAddress uintptr, cb CallBackFunction) {
-	bp := t.ConvertOffsetToAddress(breakAddress)
+func (t *Tracer) SetHWBreakpointRelative(breakAddress uintptr, cb CallBackFunction) error {
+	bp, err := t.RelativeAddress(breakAddress)
+	if err != nil {
+		return err
+	}
 	t.setHWBreakpoint(bp, cb)
+	return nil
 }
 
 func (t *Tracer) SetHWBreakpointAbsolute(breakAddress uintptr, cb CallBackFunction) {
 	t.setHWBreakpoint(breakAddress, cb)
 }
 
+// setPrototype registers how CBFunctionArgs should decode the arguments of the
+// function at breakAddress. proto is either a C prototype or a printf like format
+func (t *Tracer) setPrototype(breakAddress uintptr, proto string) error {
+	var fp *FunctionPrototype
+	var err error
+
+	if strings.Contains(proto, "(") {
+		fp, err = ParsePrototype(proto)
+	} else {
+		fp, err = ParseFormat(proto)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.prototypes[breakAddress] = fp
+	if breakpoint, ok := t.session.Root().space.breakpoints[breakAddress]; ok {
+		breakpoint.Prototype = fp
+	}
+	if breakpoint, ok := t.hwbreakpoints[breakAddress]; ok {
+		breakpoint.Prototype = fp
+	}
+	return nil
+}
+
+func (t *Tracer) SetPrototypeRelative(breakAddress uintptr, proto string) error {
+	bp, err := t.RelativeAddress(breakAddress)
+	if err != nil {
+		return err
+	}
+	return t.setPrototype(bp, proto)
+}
+
+func (t *Tracer) SetPrototypeAbsolute(breakAddress uintptr, proto string) error {
+	return t.setPrototype(breakAddress, proto)
+}
+
+// Stop removes all breakpoints and detaches, Start returns once done. The
+// threads are interrupted with PTRACE_INTERRUPT, no signal is sent.
 func (t *Tracer) Stop() {
 	shutdownFlag = true
-	unix.Kill(t.Process.Pid, unix.SIGUSR2)
+	wake := func() {
+		t.resume()
+		for tid := range t.threads {
+			unix.PtraceInterrupt(tid)
+		}
+	}
+	// Called from a callback we're on the tracer thread already and the loop
+	// checks shutdownFlag after the callback returns
+	select {
+	case t.requests <- wake:
+	default:
+		go t.request(wake)
+	}
 }
 
 func (t *Tracer) input() {
-	fmt.Printf("\n(C)ontinue, (I)gnore <thread/pid> or (Q)uit?\n")
+	fmt.Printf("\n(C)ontinue, (I)gnore <thread/pid>, (S)earch <pattern>, (D)isassemble [count] [thread] or (Q)uit?\n")
 	var cmdRegMatch = regexp.MustCompile(`^(?P<cmd>.)[\s+]?(?P<args>.*)$`)
 
 	for {
@@ -635,9 +736,49 @@ func (t *Tracer) input() {
 				if err == nil {
 					for p := range pids {
 						log.Println("Adding pid to ignore list:", pids[p])
-						t.ignoredPids[pids[p]] = true
+						if err := t.IgnoreThread(pids[p], true); err != nil {
+							log.Println(err)
+						}
 					}
 				}
+			case "S":
+				pattern, err := parseSearchInput(result["args"])
+				if err != nil {
+					fmt.Printf("Invalid pattern: %v\n", err)
+					continue
+				}
+				var matches []SearchMatch
+				t.request(func() { matches, err = t.SearchMemory(pattern, SearchOptions{MaxResults: 100}) })
+				if err != nil {
+					fmt.Printf("Search failed: %v\n", err)
+				}
+				for _, m := range matches {
+					fmt.Printf("0x%012x %s\n", m.Address, m.Map.Pathname)
+				}
+				fmt.Printf("%d matches\n", len(matches))
+				continue
+			case "D":
+				args, err := parseNumbers(result["args"])
+				if err != nil {
+					fmt.Printf("Invalid arguments: %v\n", err)
+					continue
+				}
+				count, tid := defaultDisassembleCount, t.activeTid
+				if len(args) > 0 {
+					count = args[0]
+				}
+				if len(args) > 1 {
+					tid = args[1]
+				}
+				if count <= 0 {
+					fmt.Printf("Invalid instruction count %d\n", count)
+					continue
+				}
+				t.request(func() { err = t.disassembleAt(tid, count) })
+				if err != nil {
+					fmt.Printf("Disassemble failed: %v\n", err)
+				}
+				continue
 			case "Q":
 				t.Stop()
 
@@ -44,28 +44,34 @@ func parseNumbers(input string) ([]int, error) {
 }
 
 func Dump(buff []byte) {
-	n := len(buff)
-	rowcount := 0
-	stop := (n / 16) * 16
-	cnt := 0
-	for i := 0; i <= stop; i += 16 {
-		cnt++
+	dump(buff, 0, "0x%04x:  ", nil)
+}
 
-		if i+16 <= n {
-			rowcount = 16
-		} else {
-			rowcount = min(cnt*16, n) % 16
-			if 0 == rowcount {
-				break
-			}
-		}
+// DumpDiff prints a hexdump of newBuff at address addr, highlighting bytes that
+// differ from oldBuff
+func DumpDiff(addr uintptr, oldBuff []byte, newBuff []byte) {
+	changed := make([]bool, len(newBuff))
+	for i := range newBuff {
+		changed[i] = i >= len(oldBuff) || oldBuff[i] != newBuff[i]
+	}
+	dump(newBuff, addr, "0x%012x:  ", changed)
+}
+
+func dump(buff []byte, base uintptr, offsetFmt string, highlight []bool) {
+	n := len(buff)
+	for i := 0; i < n; i += 16 {
+		rowcount := min(16, n-i)
 
 		// Print offset
-		fmt.Printf("0x%04x:  %s", i, Green)
+		fmt.Printf(offsetFmt+"%s", base+uintptr(i), Green)
 
 		// Print hex
 		for j := 0; j < rowcount; j++ {
-			fmt.Printf("%02x ", buff[i+j])
+			if highlight != nil && highlight[i+j] {
+				fmt.Printf("%s%02x%s ", Red, buff[i+j], Green)
+			} else {
+				fmt.Printf("%02x ", buff[i+j])
+			}
 			if j == (rowcount/2)-1 {
 				fmt.Printf(" ")
 			}
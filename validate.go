@@ -0,0 +1,70 @@
+package riptracer
+
+import (
+	"debug/elf"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// validateBreakpoint checks that addr is the start of an instruction in
+// executable memory before a 0xCC is written there
+func (p *TracedProcess) validateBreakpoint(addr uintptr) error {
+	t := p.tracer
+	procMaps, err := p.MemMaps()
+	if err != nil {
+		return err
+	}
+	m := findMapping(procMaps, addr)
+	if m == nil {
+		return fmt.Errorf("Address 0x%x isn't mapped in process %d", addr, p.Pid)
+	}
+	if m.Perms == nil || !m.Perms.Execute {
+		return fmt.Errorf("Address 0x%x is in non executable mapping 0x%x-0x%x %s", addr, m.StartAddr, m.EndAddr, m.Pathname)
+	}
+
+	code, err := t.originalCode(p.Pid, addr, 1)
+	if err != nil {
+		return err
+	}
+	if code[0] == 0xCC {
+		log.Printf("%sWarning: 0x%x already holds an int3 not set by us%s", Yellow, addr, Reset)
+	}
+
+	// Anonymous memory, e.g. JIT compiled code, has no symbols to check against
+	if !strings.HasPrefix(m.Pathname, "/") {
+		return nil
+	}
+	resolver, err := t.resolverForPath(m.Pathname)
+	if err != nil {
+		return nil
+	}
+	vaddr := uint64(addr-fileStart(procMaps, m)) + resolver.LoadAddr
+	if resolver.inPLT(vaddr) {
+		log.Printf("%sWarning: 0x%x is in the PLT of %s, only calls through the PLT hit it%s", Yellow, addr, m.Pathname, Reset)
+	}
+
+	sym, offset, err := resolver.GetSymbolByOffset(vaddr)
+	if err != nil || offset == 0 || elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
+		return nil
+	}
+	start := addr - uintptr(offset)
+	code, err = t.originalCode(p.Pid, start, int(offset)+maxInsnLength)
+	if err != nil {
+		return err
+	}
+	pos, last := 0, 0
+	for pos < int(offset) {
+		inst, err := decodeInstruction(code[pos:], disasmMode)
+		if err != nil {
+			// Data in the code, the boundaries can't be known
+			return nil
+		}
+		last = pos
+		pos += inst.Len
+	}
+	if pos != int(offset) {
+		return fmt.Errorf("Address 0x%x is inside the instruction of %s at 0x%x (%s+0x%x)", addr, sym.Name, start+uintptr(last), sym.Name, last)
+	}
+	return nil
+}
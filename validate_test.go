@@ -0,0 +1,136 @@
+package riptracer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/procfs"
+)
+
+func TestDecodeInstructionEndbr(t *testing.T) {
+	for _, code := range [][]byte{
+		{0xf3, 0x0f, 0x1e, 0xfa, 0x55},       // endbr64
+		{0xf3, 0x48, 0x0f, 0x1e, 0xc8, 0x55}, // rdsspq rax
+		{0x0f, 0x1f, 0x40, 0x00, 0x55},       // nop [rax]
+	} {
+		inst, err := decodeInstruction(code, 64)
+		if err != nil || inst.Len != len(code)-1 {
+			t.Errorf("Decoding % x: length %d, %v", code, inst.Len, err)
+		}
+	}
+}
+
+func TestValidateBreakpoint(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd, err := launchTraced(LaunchOptions{Args: []string{"/bin/true"}})
+	skipWithoutPtrace(t, err)
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	p := newTestTracer(t, cmd.Process.Pid).session.Root()
+
+	maps, err := p.MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data, code uintptr
+	for _, m := range maps {
+		if m.Perms.Execute && code == 0 {
+			code = m.StartAddr
+		}
+		if !m.Perms.Execute && data == 0 {
+			data = m.StartAddr
+		}
+	}
+
+	if err := p.validateBreakpoint(data); err == nil || !strings.Contains(err.Error(), "non executable") {
+		t.Errorf("Breakpoint in data at 0x%x: %v", data, err)
+	}
+	if err := p.validateBreakpoint(0); err == nil || !strings.Contains(err.Error(), "isn't mapped") {
+		t.Errorf("Breakpoint at 0: %v", err)
+	}
+	if err := p.validateBreakpoint(code); err != nil {
+		t.Errorf("Breakpoint in code at 0x%x: %v", code, err)
+	}
+}
+
+func TestValidateBreakpointWarnings(t *testing.T) {
+	tracer, err := NewTracerLaunch(LaunchOptions{Args: []string{"/bin/true"}, StopAt: StopAtEntry})
+	skipWithoutPtrace(t, err)
+	defer runtime.UnlockOSThread()
+	defer tracer.cmd.Wait()
+	defer tracer.Process.Kill()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	p := tracer.session.Root()
+	procMaps, err := p.MemMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var libc *procfs.ProcMap
+	for _, m := range procMaps {
+		if strings.Contains(filepath.Base(m.Pathname), "libc") {
+			libc = m
+			break
+		}
+	}
+	if libc == nil {
+		t.Skip("/bin/true isn't linked against libc")
+	}
+	resolver, err := tracer.resolverForPath(libc.Pathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first instruction of a function is longer than a byte
+	sym, err := resolver.GetSymbolByName("malloc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fileStart(procMaps, libc) + uintptr(sym.Value-resolver.LoadAddr)
+	code, err := tracer.originalCode(p.Pid, addr, maxInsnLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := decodeInstruction(code, disasmMode); err != nil || inst.Len < 2 {
+		t.Skipf("First instruction of malloc is %d bytes: %v", inst.Len, err)
+	}
+	if err := p.validateBreakpoint(addr + 1); err == nil || !strings.Contains(err.Error(), "inside the instruction") {
+		t.Errorf("Breakpoint in the middle of an instruction at 0x%x: %v", addr+1, err)
+	}
+
+	exe, err := p.Resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exe.plts) > 0 {
+		bias, err := p.LoadBias()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plt := bias + uintptr(exe.plts[0].Addr)
+		logged.Reset()
+		if err := p.validateBreakpoint(plt); err != nil || !strings.Contains(logged.String(), "in the PLT") {
+			t.Errorf("Breakpoint in the PLT at 0x%x: %v, logged %q", plt, err, logged.String())
+		}
+	}
+
+	// An int3 compiled into the program or left by another debugger
+	if _, err := tracer.Memory(p.Pid).WriteAt([]byte{0xCC}, addr); err != nil {
+		t.Fatal(err)
+	}
+	logged.Reset()
+	if err := p.validateBreakpoint(addr); err != nil || !strings.Contains(logged.String(), "already holds an int3") {
+		t.Errorf("Breakpoint on an int3 at 0x%x: %v, logged %q", addr, err, logged.String())
+	}
+}
@@ -0,0 +1,188 @@
+package riptracer
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// protectedPage is a page made read-only to catch writes to a watched range
+type protectedPage struct {
+	prot int // Protection to restore
+	refs int // Number of watchpoints on this page
+}
+
+// threadForInjection returns a thread that is known to be stopped
+func (t *Tracer) threadForInjection() int {
+	if t.activeTid != 0 {
+		return t.activeTid
+	}
+	return t.Process.Pid
+}
+
+// pageRange returns the first and the last page of [addr, addr+size)
+func pageRange(addr uintptr, size int) (uintptr, uintptr) {
+	pageSize := uintptr(os.Getpagesize())
+	first := addr &^ (pageSize - 1)
+	last := (addr + uintptr(size) - 1) &^ (pageSize - 1)
+	return first, last
+}
+
+func (t *Tracer) setPageWatchpoint(addr uintptr, size int, cb CallBackFunction) error {
+	if size <= 0 {
+		return fmt.Errorf("Invalid watchpoint size %d", size)
+	}
+
+	watch, ok := t.pagewatchpoints[addr]
+	if ok {
+		log.Printf("Watchpoint at 0x%x already set, adding cb...", addr)
+		watch.Callbacks = append(watch.Callbacks, cb)
+		return nil
+	}
+
+	procMaps, err := t.GetMemMaps()
+	if err != nil {
+		return err
+	}
+
+	tid := t.threadForInjection()
+	pages := t.session.Root().space.protectedPages
+	pageSize := uintptr(os.Getpagesize())
+	first, last := pageRange(addr, size)
+
+	for page := first; page <= last; page += pageSize {
+		if p, ok := pages[page]; ok {
+			p.refs++
+			continue
+		}
+
+		m := findMapping(procMaps, page)
+		if m == nil || m.Perms == nil || !m.Perms.Write {
+			return fmt.Errorf("Page 0x%x isn't writable, nothing to watch", page)
+		}
+		prot := unix.PROT_READ | unix.PROT_WRITE
+		if m.Perms.Execute {
+			prot |= unix.PROT_EXEC
+		}
+
+		if _, err := t.injectSyscall(tid, unix.SYS_MPROTECT, uint64(page), uint64(pageSize), uint64(prot&^unix.PROT_WRITE)); err != nil {
+			return fmt.Errorf("mprotect of 0x%x failed: %v", page, err)
+		}
+		pages[page] = &protectedPage{prot: prot, refs: 1}
+	}
+
+	log.Printf("Setting Page Watchpoint at 0x%x (%d bytes)", addr, size)
+	t.pagewatchpoints[addr] = &BreakPoint{Address: addr, Size: size, Callbacks: []CallBackFunction{cb}}
+	return nil
+}
+
+func (t *Tracer) SetPageWatchpointRelative(addr uintptr, size int, cb CallBackFunction) error {
+	watched, err := t.RelativeAddress(addr)
+	if err != nil {
+		return err
+	}
+	return t.setPageWatchpoint(watched, size, cb)
+}
+
+// SetPageWatchpointAbsolute reports writes to [addr, addr+size) by making its
+// pages read-only. The callbacks run before the write happens. Writes by the
+// kernel, e.g. read(2) into a watched buffer, aren't seen and fail with EFAULT.
+// Other threads can write unnoticed while a page is writable again for the
+// faulting instruction unless all-stop mode is enabled.
+func (t *Tracer) SetPageWatchpointAbsolute(addr uintptr, size int, cb CallBackFunction) error {
+	return t.setPageWatchpoint(addr, size, cb)
+}
+
+// RemovePageWatchpoint removes the watchpoint at addr, restoring the page
+// protection once no other watchpoint needs it
+func (t *Tracer) RemovePageWatchpoint(addr uintptr) error {
+	watch, ok := t.pagewatchpoints[addr]
+	if !ok {
+		return fmt.Errorf("No watchpoint at 0x%x", addr)
+	}
+	delete(t.pagewatchpoints, addr)
+
+	tid := t.threadForInjection()
+	pages := t.session.Root().space.protectedPages
+	pageSize := uintptr(os.Getpagesize())
+	first, last := pageRange(watch.Address, watch.Size)
+
+	for page := first; page <= last; page += pageSize {
+		p, ok := pages[page]
+		if !ok {
+			continue
+		}
+		p.refs--
+		if p.refs > 0 {
+			continue
+		}
+		delete(pages, page)
+		if _, err := t.injectSyscall(tid, unix.SYS_MPROTECT, uint64(page), uint64(pageSize), uint64(p.prot)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlePageFault checks whether the SIGSEGV that stopped tid was caused by a
+// page we protected. If so it reports watched accesses, lets the faulting
+// instruction complete and returns true. The caller continues the thread
+// without delivering the signal.
+func (t *Tracer) handlePageFault(tid int) bool {
+	info, err := ptraceGetSiginfo(tid)
+	if err != nil {
+		return false
+	}
+	fault := siginfoAddr(info)
+	pageSize := uintptr(os.Getpagesize())
+	page := fault &^ (pageSize - 1)
+	protected := t.processOf(tid).space.protectedPages
+
+	if _, ok := protected[page]; !ok {
+		return false
+	}
+
+	if t.allStop {
+		stopped := t.stopThreads(tid)
+		defer t.resumeThreads(stopped)
+	}
+
+	for _, watch := range t.pagewatchpoints {
+		if fault >= watch.Address && fault < watch.Address+uintptr(watch.Size) && t.hitBreakpoint(tid, watch) {
+			if t.verbose {
+				log.Printf("PID: %d Hit Watchpoint at 0x%x (access 0x%x, %d times)", tid, watch.Address, fault, watch.Hits)
+			}
+			hit := *watch
+			hit.AccessAddress = fault
+			for idx := range watch.Callbacks {
+				watch.Callbacks[idx](tid, hit)
+			}
+		}
+	}
+
+	// An access can cross into the next page, which has to be writable for
+	// the step as well
+	pages := []uintptr{page}
+	if _, ok := protected[page+pageSize]; ok {
+		pages = append(pages, page+pageSize)
+	}
+	for _, pg := range pages {
+		prot := protected[pg].prot
+		if _, err := t.injectSyscall(tid, unix.SYS_MPROTECT, uint64(pg), uint64(pageSize), uint64(prot)); err != nil {
+			log.Printf("Failed to unprotect page 0x%x: %v", pg, err)
+			return false
+		}
+	}
+	if err := t.stepThread(tid); err != nil {
+		log.Printf("Failed to step over watched access: %v", err)
+	}
+	for _, pg := range pages {
+		prot := protected[pg].prot
+		if _, err := t.injectSyscall(tid, unix.SYS_MPROTECT, uint64(pg), uint64(pageSize), uint64(prot&^unix.PROT_WRITE)); err != nil {
+			log.Printf("Failed to protect page 0x%x again: %v", pg, err)
+		}
+	}
+	return true
+}
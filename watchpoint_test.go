@@ -0,0 +1,51 @@
+package riptracer
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+const watchProgram = `
+#include <pthread.h>
+#include <stdio.h>
+int watched[1024] __attribute__((aligned(4096)));
+__attribute__((noinline)) void hit(int i) { watched[0] = i; }
+static void *run(void *arg) { for (int i = 0; i < 200; i++) hit(i); return 0; }
+int main(void) {
+	pthread_t threads[4];
+	for (int i = 0; i < 4; i++) pthread_create(&threads[i], 0, run, 0);
+	for (int i = 0; i < 4; i++) pthread_join(threads[i], 0);
+	puts("ok");
+	return 0;
+}
+`
+
+func TestPageWatchpointThreads(t *testing.T) {
+	prog := compileTestProgram(t, watchProgram, "-O0", "-pthread")
+
+	for _, allStop := range []bool{false, true} {
+		var stdout bytes.Buffer
+		tracer := launchTestTracer(t, LaunchOptions{Args: []string{prog}, Stdout: &stdout, StopAt: StopAtMain})
+		tracer.SetAllStop(allStop)
+		addr, err := tracer.Session().Root().SymbolAddress("watched")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits := 0
+		if err := tracer.SetPageWatchpointAbsolute(addr, 4, func(int, BreakPoint) { hits++ }); err != nil {
+			t.Fatal(err)
+		}
+		tracer.Start()
+		runtime.UnlockOSThread()
+
+		// Threads running the code the page fault is handled for must not notice
+		if stdout.String() != "ok\n" {
+			t.Errorf("All-stop %t: output %q", allStop, stdout.String())
+		}
+		// Other threads can write unnoticed unless all-stop mode is on
+		if hits == 0 || hits > 800 || (allStop && hits != 800) {
+			t.Errorf("All-stop %t: %d hits", allStop, hits)
+		}
+	}
+}